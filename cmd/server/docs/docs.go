@@ -89,16 +89,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/config/default-route": {
+        "/api/config/client-limits": {
             "get": {
-                "description": "Get the configured default route when root route is requested",
+                "description": "Get the default request body size, header size and body read timeout limits, and the per-IP connection limit of the proxy ports",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "config"
                 ],
-                "summary": "Get default route",
+                "summary": "Get client limits",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -111,7 +111,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "type": "string"
+                                            "$ref": "#/definitions/models.ClientLimits"
                                         }
                                     }
                                 }
@@ -121,7 +121,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Set the configured default route when root route is requested",
+                "description": "Set max_body_size (bytes), max_header_bytes, read_timeout (seconds) and max_conns_per_ip, 0 disables a limit. Rules can override the first three.",
                 "consumes": [
                     "application/json"
                 ],
@@ -131,15 +131,15 @@ const docTemplate = `{
                 "tags": [
                     "config"
                 ],
-                "summary": "Set default route",
+                "summary": "Set client limits",
                 "parameters": [
                     {
-                        "description": "Route configuration, example: {\\",
-                        "name": "rule",
+                        "description": "Client limits",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.ClientLimits"
                         }
                     }
                 ],
@@ -147,7 +147,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.ClientLimits"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
@@ -159,16 +171,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/config/proxy-protocol": {
+        "/api/config/default-route": {
             "get": {
-                "description": "Get whether the proxy port requires Proxy Protocol header",
+                "description": "Get the configured default route when root route is requested",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "config"
                 ],
-                "summary": "Get proxy protocol force",
+                "summary": "Get default route",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -181,7 +193,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/admin.proxyProtocolForceResponse"
+                                            "type": "string"
                                         }
                                     }
                                 }
@@ -191,7 +203,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Enable or disable requiring Proxy Protocol header on proxy port",
+                "description": "Set the configured default route when root route is requested",
                 "consumes": [
                     "application/json"
                 ],
@@ -201,15 +213,15 @@ const docTemplate = `{
                 "tags": [
                     "config"
                 ],
-                "summary": "Set proxy protocol force",
+                "summary": "Set default route",
                 "parameters": [
                     {
-                        "description": "Proxy protocol options",
-                        "name": "request",
+                        "description": "Route configuration, example: {\\",
+                        "name": "rule",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/admin.proxyProtocolForceRequest"
+                            "type": "string"
                         }
                     }
                 ],
@@ -217,19 +229,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "allOf": [
-                                {
-                                    "$ref": "#/definitions/response.Response"
-                                },
-                                {
-                                    "type": "object",
-                                    "properties": {
-                                        "data": {
-                                            "$ref": "#/definitions/admin.proxyProtocolForceResponse"
-                                        }
-                                    }
-                                }
-                            ]
+                            "$ref": "#/definitions/response.Response"
                         }
                     },
                     "400": {
@@ -241,16 +241,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/info": {
+        "/api/config/fallback": {
             "get": {
-                "description": "Get version and other server info",
+                "description": "Get how requests that match no rule path are routed (cookie / Referer strategies, strict mode, debug header)",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "info"
+                    "config"
                 ],
-                "summary": "Get server info",
+                "summary": "Get fallback routing",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -263,7 +263,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/admin.ServerInfo"
+                                            "$ref": "#/definitions/models.FallbackConfig"
                                         }
                                     }
                                 }
@@ -271,11 +271,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/api/iptables/allow": {
+            },
             "post": {
-                "description": "Add an ALLOW rule for a specific IP",
+                "description": "Set the ordered fallback strategies (\"cookie\", \"referer\", empty list disables), strict mode and the X-Proxy-Route debug header",
                 "consumes": [
                     "application/json"
                 ],
@@ -283,17 +281,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Allow IP",
+                "summary": "Set fallback routing",
                 "parameters": [
                     {
-                        "description": "IP to allow",
+                        "description": "Fallback routing options",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/iptables.ipRequest"
+                            "$ref": "#/definitions/models.FallbackConfig"
                         }
                     }
                 ],
@@ -301,7 +299,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.FallbackConfig"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
@@ -309,45 +319,43 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
                     }
                 }
             }
         },
-        "/api/iptables/allow-all": {
-            "post": {
-                "description": "Remove the catch-all DROP rule",
+        "/api/config/geoip": {
+            "get": {
+                "description": "Get the MMDB database paths, the reload interval and the countries blocked via iptables",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Allow all traffic",
+                "summary": "Get GeoIP configuration",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.GeoIPConfig"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     }
                 }
-            }
-        },
-        "/api/iptables/block": {
+            },
             "post": {
-                "description": "Add a DROP rule for a specific IP",
+                "description": "Set absolute paths of the country and ASN MMDB databases (may be the same file, empty disables), how often they are checked for changes and the countries whose networks are dropped in the iptables chain",
                 "consumes": [
                     "application/json"
                 ],
@@ -355,17 +363,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Block IP",
+                "summary": "Set GeoIP configuration",
                 "parameters": [
                     {
-                        "description": "IP to block",
+                        "description": "GeoIP configuration",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/iptables.ipRequest"
+                            "$ref": "#/definitions/models.GeoIPConfig"
                         }
                     }
                 ],
@@ -373,7 +381,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.GeoIPConfig"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
@@ -381,61 +401,85 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
                     }
                 }
             }
         },
-        "/api/iptables/block-all": {
-            "post": {
-                "description": "Add a catch-all DROP rule at the end of the chain",
+        "/api/config/ip-filter": {
+            "get": {
+                "description": "Get the client IP allow/deny lists applied to every request on the proxy ports before rule filters",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Block all traffic",
+                "summary": "Get global IP filter",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.IPFilter"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     }
                 }
-            }
-        },
-        "/api/iptables/clean": {
+            },
             "post": {
-                "description": "Remove the custom iptables chain",
+                "description": "Set the allow and deny lists (IPs or CIDRs, deny wins, a non-empty allow list admits only matching clients) and the action for denied clients: \"forbid\" (403 page) or \"drop\" (close the connection)",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
+                ],
+                "summary": "Set global IP filter",
+                "parameters": [
+                    {
+                        "description": "Global IP filter",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.IPFilter"
+                        }
+                    }
                 ],
-                "summary": "Clean iptables",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.IPFilter"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
@@ -443,35 +487,39 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/iptables/flush": {
-            "post": {
-                "description": "Flush all rules in the custom chain",
+        "/api/config/proxy-protocol": {
+            "get": {
+                "description": "Get whether the proxy port requires Proxy Protocol header",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Flush iptables rules",
+                "summary": "Get proxy protocol force",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/admin.proxyProtocolForceResponse"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     }
                 }
-            }
-        },
-        "/api/iptables/init": {
+            },
             "post": {
-                "description": "Initialize the custom iptables chain",
+                "description": "Enable or disable requiring Proxy Protocol header on proxy port",
                 "consumes": [
                     "application/json"
                 ],
@@ -479,16 +527,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Initialize iptables",
+                "summary": "Set proxy protocol force",
                 "parameters": [
                     {
-                        "description": "Initialization options",
+                        "description": "Proxy protocol options",
                         "name": "request",
                         "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/iptables.initRequest"
+                            "$ref": "#/definitions/admin.proxyProtocolForceRequest"
                         }
                     }
                 ],
@@ -496,11 +545,23 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/admin.proxyProtocolForceResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
@@ -508,16 +569,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/iptables/list": {
+        "/api/config/rate-limit": {
             "get": {
-                "description": "List all rules in the custom chain",
+                "description": "Get the rate limit applied to every proxied request in addition to per-rule limits",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "List iptables rules",
+                "summary": "Get global rate limit",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -530,28 +591,17 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "type": "array",
-                                            "items": {
-                                                "type": "string"
-                                            }
+                                            "$ref": "#/definitions/models.RateLimit"
                                         }
                                     }
                                 }
                             ]
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
                     }
                 }
-            }
-        },
-        "/api/iptables/remove": {
+            },
             "post": {
-                "description": "Remove an ALLOW/BLOCK rule for a specific IP",
+                "description": "Set the token bucket applied to every proxied request: rate (requests per second, 0 disables), burst and key (\"ip\", \"identity\" or \"header:\u003cname\u003e\")",
                 "consumes": [
                     "application/json"
                 ],
@@ -559,17 +609,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "iptables"
+                    "config"
                 ],
-                "summary": "Remove IP rule",
+                "summary": "Set global rate limit",
                 "parameters": [
                     {
-                        "description": "IP to remove",
+                        "description": "Global rate limit",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/iptables.ipRequest"
+                            "$ref": "#/definitions/models.RateLimit"
                         }
                     }
                 ],
@@ -577,7 +627,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.RateLimit"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
@@ -585,26 +647,20 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
                     }
                 }
             }
         },
-        "/api/rules": {
+        "/api/config/target-allowlist": {
             "get": {
-                "description": "Get all configured proxy rules",
+                "description": "Get the external hosts, IPs and CIDRs that proxy rules may target",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "rules"
+                    "config"
                 ],
-                "summary": "Get all rules",
+                "summary": "Get target allowlist",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -617,10 +673,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "type": "array",
-                                            "items": {
-                                                "$ref": "#/definitions/models.Rule"
-                                            }
+                                            "$ref": "#/definitions/admin.targetAllowlistRequest"
                                         }
                                     }
                                 }
@@ -630,7 +683,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Set proxy rules (overrides existing rules)",
+                "description": "Replace the external hosts (\"api.example.com\", \"*.example.com\"), IPs and CIDRs that proxy rules may target. Other targets must resolve to internal addresses; this is checked on every connection.",
                 "consumes": [
                     "application/json"
                 ],
@@ -638,20 +691,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "rules"
+                    "config"
                 ],
-                "summary": "Set rules",
+                "summary": "Set target allowlist",
                 "parameters": [
                     {
-                        "description": "List of rules to set",
-                        "name": "rules",
+                        "description": "Allowlist entries",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Rule"
-                            }
+                            "$ref": "#/definitions/admin.targetAllowlistRequest"
                         }
                     }
                 ],
@@ -667,10 +717,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "type": "array",
-                                            "items": {
-                                                "$ref": "#/definitions/models.Rule"
-                                            }
+                                            "$ref": "#/definitions/admin.targetAllowlistRequest"
                                         }
                                     }
                                 }
@@ -684,36 +731,18 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Remove all proxy rules",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "rules"
-                ],
-                "summary": "Flush all rules",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    }
-                }
             }
         },
-        "/api/ssl": {
+        "/api/config/trusted-proxies": {
             "get": {
-                "description": "Check if dynamic SSL is currently enabled and configured on the proxy port",
+                "description": "Get the networks allowed to report client addresses through PROXY protocol or forwarded headers",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "ssl"
+                    "config"
                 ],
-                "summary": "Get SSL status",
+                "summary": "Get trusted proxies",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -726,7 +755,7 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/models.SSLInfo"
+                                            "$ref": "#/definitions/models.TrustedProxyConfig"
                                         }
                                     }
                                 }
@@ -736,7 +765,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Upload a PEM encoded certificate and private key to enable HTTPS on the proxy port",
+                "description": "Set trusted proxy networks with their PROXY protocol policy (\"use\", \"require\", \"ignore\", \"reject\") and whether X-Forwarded-For / X-Real-IP are honored. Other sources never set the client address.",
                 "consumes": [
                     "application/json"
                 ],
@@ -744,17 +773,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "ssl"
+                    "config"
                 ],
-                "summary": "Set SSL certificate",
+                "summary": "Set trusted proxies",
                 "parameters": [
                     {
-                        "description": "SSL Certificate and Key",
-                        "name": "ssl",
+                        "description": "Trusted proxy networks",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.SSLRequest"
+                            "$ref": "#/definitions/models.TrustedProxyConfig"
                         }
                     }
                 ],
@@ -762,7 +791,19 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.Response"
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.TrustedProxyConfig"
+                                        }
+                                    }
+                                }
+                            ]
                         }
                     },
                     "400": {
@@ -772,19 +813,48 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Clear the configured SSL certificate and disable HTTPS on the proxy port",
+            }
+        },
+        "/api/geoip/lookup": {
+            "get": {
+                "description": "Get the country and ASN the loaded databases report for an IP address",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "ssl"
+                    "geoip"
+                ],
+                "summary": "Look up an IP address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "IP address",
+                        "name": "ip",
+                        "in": "query",
+                        "required": true
+                    }
                 ],
-                "summary": "Clear SSL certificate",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/geoip.Record"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
@@ -792,16 +862,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/traffic": {
+        "/api/geoip/status": {
             "get": {
-                "description": "Get proxy traffic stats (bytes in/out, active logged-in users in last 2 minutes, and 5xx count)",
+                "description": "Get the type, build time and load time of each configured database, and the last load error",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "traffic"
+                    "geoip"
                 ],
-                "summary": "Get traffic stats",
+                "summary": "Get GeoIP database status",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -814,7 +884,10 @@ const docTemplate = `{
                                     "type": "object",
                                     "properties": {
                                         "data": {
-                                            "$ref": "#/definitions/proxy.TrafficStats"
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/geoip.DatabaseStatus"
+                                            }
                                         }
                                     }
                                 }
@@ -823,28 +896,871 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "admin.ServerInfo": {
-            "type": "object",
-            "properties": {
-                "version": {
-                    "type": "string",
-                    "example": "0.0.1"
+        },
+        "/api/info": {
+            "get": {
+                "description": "Get version and other server info",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "info"
+                ],
+                "summary": "Get server info",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/admin.ServerInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
                 }
             }
         },
-        "admin.proxyProtocolForceRequest": {
-            "type": "object",
-            "properties": {
-                "proxy_protocol_force": {
-                    "type": "boolean",
-                    "example": true
+        "/api/iptables/allow": {
+            "post": {
+                "description": "Add an ALLOW rule for a specific IP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Allow IP",
+                "parameters": [
+                    {
+                        "description": "IP to allow",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/iptables.ipRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
                 }
             }
         },
-        "admin.proxyProtocolForceResponse": {
+        "/api/iptables/allow-all": {
+            "post": {
+                "description": "Remove the catch-all DROP rule",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Allow all traffic",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/block": {
+            "post": {
+                "description": "Add a DROP rule for a specific IP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Block IP",
+                "parameters": [
+                    {
+                        "description": "IP to block",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/iptables.ipRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/block-all": {
+            "post": {
+                "description": "Add a catch-all DROP rule at the end of the chain",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Block all traffic",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/clean": {
+            "post": {
+                "description": "Remove the custom iptables chain",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Clean iptables",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/flush": {
+            "post": {
+                "description": "Flush all rules in the custom chain",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Flush iptables rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/init": {
+            "post": {
+                "description": "Initialize the custom iptables chain",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Initialize iptables",
+                "parameters": [
+                    {
+                        "description": "Initialization options",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/iptables.initRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/list": {
+            "get": {
+                "description": "List all rules in the custom chain",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "List iptables rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "type": "string"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/iptables/remove": {
+            "post": {
+                "description": "Remove an ALLOW/BLOCK rule for a specific IP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "iptables"
+                ],
+                "summary": "Remove IP rule",
+                "parameters": [
+                    {
+                        "description": "IP to remove",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/iptables.ipRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/listeners": {
+            "get": {
+                "description": "Get the additional proxy listeners and their rules. Private keys are not returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "listeners"
+                ],
+                "summary": "Get listeners",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.PortConfig"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Set additional proxy listeners, each with its own rules, default route, PROXY protocol policy and optional certificate. Listeners are started, stopped and rebound immediately.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "listeners"
+                ],
+                "summary": "Set listeners",
+                "parameters": [
+                    {
+                        "description": "List of listeners to set",
+                        "name": "listeners",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.PortConfig"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.PortConfig"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Stop and remove all additional proxy listeners",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "listeners"
+                ],
+                "summary": "Flush listeners",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/rules": {
+            "get": {
+                "description": "Get all configured proxy rules",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rules"
+                ],
+                "summary": "Get all rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.Rule"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Set proxy rules (overrides existing rules). The rules are validated first, an invalid rule leaves the current rules unchanged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rules"
+                ],
+                "summary": "Set rules",
+                "parameters": [
+                    {
+                        "description": "List of rules to set",
+                        "name": "rules",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Rule"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.Rule"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Remove all proxy rules",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rules"
+                ],
+                "summary": "Flush all rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/rules/match": {
+            "delete": {
+                "description": "Remove the rule whose path, match_type, match_pattern, methods, match_headers, match_query and match_cookies equal the given ones. Other rules on the same path are kept. Method order and header name case are ignored.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rules"
+                ],
+                "summary": "Remove a rule",
+                "parameters": [
+                    {
+                        "description": "Match fields of the rule to remove",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Rule"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/ssl": {
+            "get": {
+                "description": "Check if dynamic SSL is currently enabled and configured on the proxy port",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ssl"
+                ],
+                "summary": "Get SSL status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/models.SSLInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Upload a PEM encoded certificate and private key to enable HTTPS on the proxy port",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ssl"
+                ],
+                "summary": "Set SSL certificate",
+                "parameters": [
+                    {
+                        "description": "SSL Certificate and Key",
+                        "name": "ssl",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.SSLRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Clear the configured SSL certificate and disable HTTPS on the proxy port",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ssl"
+                ],
+                "summary": "Clear SSL certificate",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/traffic": {
+            "get": {
+                "description": "Get proxy traffic stats (bytes in/out, active logged-in users in last 2 minutes, 5xx count, rate limited requests, queued requests, requests rejected by concurrency limits, requests rejected by size limits or read timeouts, connections rejected by max_conns_per_ip, requests rejected by IP filters and requests rejected by GeoIP filters)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "traffic"
+                ],
+                "summary": "Get traffic stats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/proxy.TrafficStats"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/api/traffic/concurrency": {
+            "get": {
+                "description": "Get rules with max_in_flight: requests in flight, queue depth, queue wait times and requests rejected because the queue was full or timed out",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "traffic"
+                ],
+                "summary": "Get concurrency stats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/proxy.ConcurrencyStats"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/api/traffic/rate-limits": {
+            "get": {
+                "description": "Get the global and per-rule rate limiters with their settings, tracked clients and allowed / rejected request counts",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "traffic"
+                ],
+                "summary": "Get rate limit stats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/proxy.RateLimitStats"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/api/traffic/transports": {
+            "get": {
+                "description": "Get per-upstream transport stats (requests, dials, reused and open connections)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "traffic"
+                ],
+                "summary": "Get upstream transport pool stats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/proxy.TransportStats"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "admin.ServerInfo": {
+            "type": "object",
+            "properties": {
+                "version": {
+                    "type": "string",
+                    "example": "0.0.1"
+                }
+            }
+        },
+        "admin.proxyProtocolForceRequest": {
+            "type": "object",
+            "properties": {
+                "proxy_protocol_force": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "admin.proxyProtocolForceResponse": {
             "type": "object",
             "properties": {
                 "proxy_protocol_force": {
@@ -853,6 +1769,67 @@ const docTemplate = `{
                 }
             }
         },
+        "admin.targetAllowlistRequest": {
+            "type": "object",
+            "properties": {
+                "target_allowlist": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "api.example.com",
+                        "*.example.org",
+                        "203.0.113.0/24"
+                    ]
+                }
+            }
+        },
+        "geoip.DatabaseStatus": {
+            "type": "object",
+            "properties": {
+                "build_time": {
+                    "type": "string"
+                },
+                "database_type": {
+                    "type": "string",
+                    "example": "GeoLite2-Country"
+                },
+                "error": {
+                    "description": "Last load error, the previous version stays in use",
+                    "type": "string"
+                },
+                "loaded": {
+                    "type": "boolean"
+                },
+                "loaded_at": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "geoip.Record": {
+            "type": "object",
+            "properties": {
+                "as_org": {
+                    "description": "Autonomous system organization",
+                    "type": "string",
+                    "example": "Deutsche Telekom"
+                },
+                "asn": {
+                    "description": "Autonomous system number",
+                    "type": "integer",
+                    "example": 3320
+                },
+                "country": {
+                    "description": "ISO 3166-1 alpha-2 country code",
+                    "type": "string",
+                    "example": "DE"
+                }
+            }
+        },
         "iptables.initRequest": {
             "type": "object",
             "properties": {
@@ -883,68 +1860,581 @@ const docTemplate = `{
                 }
             }
         },
-        "iptables.ipRequest": {
+        "iptables.ipRequest": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "type": "string",
+                    "example": "192.168.1.100"
+                }
+            }
+        },
+        "models.AuthConfig": {
+            "type": "object",
+            "properties": {
+                "auth_cookies": {
+                    "description": "Cookies owned by the auth service, never forwarded to rules with a cookie_namespace. Cookies set through /__auth__/ are detected automatically.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "session"
+                    ]
+                },
+                "auth_port": {
+                    "description": "Local Auth Service Port",
+                    "type": "integer",
+                    "example": 3000
+                },
+                "auth_url": {
+                    "description": "Relative Verify URL (default /api/auth/verify)",
+                    "type": "string",
+                    "example": "/api/auth/verify"
+                },
+                "login_url": {
+                    "description": "Relative Login URL (default /login)",
+                    "type": "string",
+                    "example": "/login"
+                },
+                "logout_url": {
+                    "description": "Relative Logout URL (default /api/auth/logout)",
+                    "type": "string",
+                    "example": "/api/auth/logout"
+                },
+                "preflight_url": {
+                    "description": "Relative Preflight URL (default /api/auth/preflight)",
+                    "type": "string",
+                    "example": "/api/auth/preflight"
+                }
+            }
+        },
+        "models.ClientLimits": {
+            "type": "object",
+            "properties": {
+                "max_body_size": {
+                    "description": "Default maximum request body in bytes, 0 means no limit",
+                    "type": "integer",
+                    "example": 0
+                },
+                "max_conns_per_ip": {
+                    "description": "Maximum concurrent connections per client IP across all proxy ports, 0 means no limit. Trusted proxies are exempt.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "max_header_bytes": {
+                    "description": "Default maximum size of the request line and headers in bytes, 0 means no limit (the server always caps headers at 1 MB)",
+                    "type": "integer",
+                    "example": 0
+                },
+                "read_timeout": {
+                    "description": "Default seconds allowed to receive a request body, 0 means no limit",
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "models.FallbackConfig": {
+            "type": "object",
+            "properties": {
+                "debug_header": {
+                    "description": "If true, responses carry X-Proxy-Route with the strategy and rule that handled them.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "strategies": {
+                    "description": "Ordered fallback strategies: \"cookie\" (__proxy_path cookie) and \"referer\". Empty disables fallback routing.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "cookie",
+                        "referer"
+                    ]
+                },
+                "strict": {
+                    "description": "If true, all strategies that find a rule must agree on it, otherwise the request is not routed.",
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "models.GeoFilter": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "Response to denied clients: \"forbid\" (default, 403 page) or \"drop\" (connection closed without a response)",
+                    "type": "string",
+                    "example": "forbid"
+                },
+                "allow_asns": {
+                    "description": "Autonomous system numbers admitted",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        3320
+                    ]
+                },
+                "allow_countries": {
+                    "description": "ISO 3166-1 alpha-2 country codes admitted",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "DE",
+                        "AT",
+                        "CH"
+                    ]
+                },
+                "allow_unknown": {
+                    "description": "If true, clients the databases do not know (private addresses, no database loaded) pass the allow lists",
+                    "type": "boolean",
+                    "example": true
+                },
+                "deny_asns": {
+                    "description": "Autonomous system numbers rejected",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        64496
+                    ]
+                },
+                "deny_countries": {
+                    "description": "Country codes rejected",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "KP"
+                    ]
+                }
+            }
+        },
+        "models.GeoIPConfig": {
+            "type": "object",
+            "properties": {
+                "asn_db": {
+                    "description": "ASN database, optional",
+                    "type": "string",
+                    "example": "/var/lib/GeoIP/GeoLite2-ASN.mmdb"
+                },
+                "country_db": {
+                    "description": "Country database, may also contain ASN data",
+                    "type": "string",
+                    "example": "/var/lib/GeoIP/GeoLite2-Country.mmdb"
+                },
+                "iptables_block_countries": {
+                    "description": "Countries whose networks are dropped in the managed iptables chain",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "KP"
+                    ]
+                },
+                "reload_interval": {
+                    "description": "Seconds between checks of the files for changes (default 60)",
+                    "type": "integer",
+                    "example": 60
+                }
+            }
+        },
+        "models.HeaderOps": {
+            "type": "object",
+            "properties": {
+                "add": {
+                    "description": "Headers to append.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "remove": {
+                    "description": "Headers to delete.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "Server",
+                        "X-Powered-By"
+                    ]
+                },
+                "set": {
+                    "description": "Headers to set, replacing existing values.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "X-Forwarded-Prefix": "{rule_path}"
+                    }
+                }
+            }
+        },
+        "models.IPFilter": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "Response to denied clients: \"forbid\" (default, 403 page) or \"drop\" (connection closed without a response)",
+                    "type": "string",
+                    "example": "forbid"
+                },
+                "allow": {
+                    "description": "Client IPs or CIDRs admitted, empty admits everyone not denied",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "10.8.0.0/16"
+                    ]
+                },
+                "deny": {
+                    "description": "Client IPs or CIDRs rejected",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "10.8.3.0/24"
+                    ]
+                }
+            }
+        },
+        "models.PortConfig": {
+            "type": "object",
+            "properties": {
+                "bind": {
+                    "description": "Bind addresses of this port (IP literals, \"::\" is dual-stack). The global proxy bind addresses are used when empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "0.0.0.0",
+                        "::"
+                    ]
+                },
+                "default_route": {
+                    "description": "Default route of this port (default /__select__)",
+                    "type": "string",
+                    "example": "/__select__"
+                },
+                "port": {
+                    "description": "Listener port, must differ from the main proxy port and the admin port",
+                    "type": "integer",
+                    "example": 8443
+                },
+                "proxy_protocol_force": {
+                    "description": "If true, this port only binds loopback (127.0.0.1 / ::1) and loopback peers are trusted proxies",
+                    "type": "boolean",
+                    "example": false
+                },
+                "rules": {
+                    "description": "Rules served on this port",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Rule"
+                    }
+                },
+                "ssl_cert": {
+                    "description": "PEM certificate for this port. The global certificate is used when empty.",
+                    "type": "string",
+                    "example": "-----BEGIN CERT..."
+                },
+                "ssl_key": {
+                    "description": "PEM private key. Never returned; omit it to keep the stored key for an unchanged ssl_cert.",
+                    "type": "string",
+                    "example": "-----BEGIN KEY..."
+                }
+            }
+        },
+        "models.RateLimit": {
             "type": "object",
             "properties": {
-                "ip": {
+                "burst": {
+                    "description": "Bucket size, requests allowed at once (default: rate rounded up, at least 1)",
+                    "type": "integer",
+                    "example": 20
+                },
+                "key": {
+                    "description": "Client key: \"ip\" (default), \"identity\" (user reported by the auth service, falls back to the IP) or \"header:\u003cname\u003e\" (only read from requests forwarded by trusted proxies, falls back to the IP)",
                     "type": "string",
-                    "example": "192.168.1.100"
+                    "example": "ip"
+                },
+                "rate": {
+                    "description": "Requests per second, 0 disables the limit",
+                    "type": "number",
+                    "example": 10
                 }
             }
         },
-        "models.AuthConfig": {
+        "models.Rule": {
             "type": "object",
             "properties": {
-                "auth_port": {
-                    "description": "Local Auth Service Port",
-                    "type": "integer",
-                    "example": 3000
+                "body": {
+                    "description": "Static rules: response body. Maintenance rules: message shown on the page.",
+                    "type": "string",
+                    "example": "ok"
                 },
-                "auth_url": {
-                    "description": "Relative Verify URL (default /api/auth/verify)",
+                "content_type": {
+                    "description": "Static rules: Content-Type (default text/plain; charset=utf-8).",
                     "type": "string",
-                    "example": "/api/auth/verify"
+                    "example": "text/plain"
                 },
-                "login_url": {
-                    "description": "Relative Login URL (default /login)",
+                "cookie_namespace": {
+                    "description": "Proxy rules: prefix cookie names set by the upstream with \"\u003cnamespace\u003e__\", forward only them (unprefixed) plus shared cookies, and drop other namespaces and auth cookies.",
                     "type": "string",
-                    "example": "/login"
+                    "example": "app1"
                 },
-                "logout_url": {
-                    "description": "Relative Logout URL (default /api/auth/logout)",
+                "dial_timeout": {
+                    "description": "Proxy rules: upstream connect timeout in seconds (default 6).",
+                    "type": "integer",
+                    "example": 6
+                },
+                "directory_listing": {
+                    "description": "Files rules: list directories that have no index.html.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "flush_interval": {
+                    "description": "Proxy rules: response flush interval in milliseconds, -1 flushes after every write (SSE, long polling).",
+                    "type": "integer",
+                    "example": -1
+                },
+                "geo_filter": {
+                    "description": "Client countries and autonomous systems allowed or denied on this rule, resolved from the GeoIP databases.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.GeoFilter"
+                        }
+                    ]
+                },
+                "idle_timeout": {
+                    "description": "Proxy rules: seconds an idle upstream connection is kept for reuse (default 90).",
+                    "type": "integer",
+                    "example": 90
+                },
+                "inject_shim": {
+                    "description": "If true, injects a script into HTML pages that keeps fetch/XHR/WebSocket/EventSource/history URLs under Path.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "ip_filter": {
+                    "description": "Client addresses allowed or denied on this rule, checked after the global filter.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.IPFilter"
+                        }
+                    ]
+                },
+                "match_cookies": {
+                    "description": "Cookies that must be present, same value semantics as MatchHeaders.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "channel": "beta"
+                    }
+                },
+                "match_headers": {
+                    "description": "Request headers that must be present; an empty value or \"*\" only checks presence.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "X-API-Version": "2"
+                    }
+                },
+                "match_pattern": {
+                    "description": "Regex or glob pattern used when MatchType is \"regex\" or \"glob\". Path stays the public prefix.",
                     "type": "string",
-                    "example": "/api/auth/logout"
+                    "example": "/app/*/api/**"
                 },
-                "preflight_url": {
-                    "description": "Relative Preflight URL (default /api/auth/preflight)",
+                "match_query": {
+                    "description": "Query parameters that must be present, same value semantics as MatchHeaders.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "beta": "1"
+                    }
+                },
+                "match_type": {
+                    "description": "How requests are matched: \"prefix\" (default, uses Path), \"regex\" or \"glob\" (uses MatchPattern).",
                     "type": "string",
-                    "example": "/api/auth/preflight"
-                }
-            }
-        },
-        "models.Rule": {
-            "type": "object",
-            "properties": {
+                    "example": "prefix"
+                },
+                "max_body_size": {
+                    "description": "Maximum request body in bytes, answered with 413 (default: global client limit, -1 no limit).",
+                    "type": "integer",
+                    "example": 10485760
+                },
+                "max_header_bytes": {
+                    "description": "Maximum size of the request line and headers in bytes, answered with 431 (default: global client limit, -1 no limit).",
+                    "type": "integer",
+                    "example": 16384
+                },
+                "max_in_flight": {
+                    "description": "Maximum requests served by this rule at once, 0 means no limit. Further requests wait in a FIFO queue.",
+                    "type": "integer",
+                    "example": 4
+                },
+                "max_queue": {
+                    "description": "Requests allowed to wait when MaxInFlight is reached (default 0, answer 503 at once).",
+                    "type": "integer",
+                    "example": 50
+                },
+                "methods": {
+                    "description": "If set, only these HTTP methods match (GET also allows HEAD).",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "GET",
+                        "HEAD"
+                    ]
+                },
+                "no_fallback": {
+                    "description": "If true, the rule is only selected by its own path, never by cookie or Referer fallback.",
+                    "type": "boolean",
+                    "example": false
+                },
                 "path": {
                     "description": "Path prefix to match (e.g., \"/api\")",
                     "type": "string",
                     "example": "/api"
                 },
+                "priority": {
+                    "description": "Breaks ties between rules with the same match length: higher priority wins, then the rule with more predicates.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "queue_timeout": {
+                    "description": "Seconds a request may wait in the queue before it is answered with 503 (default 10).",
+                    "type": "integer",
+                    "example": 10
+                },
+                "rate_limit": {
+                    "description": "Requests per client allowed on this rule, applied in addition to the global limit.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.RateLimit"
+                        }
+                    ]
+                },
+                "read_timeout": {
+                    "description": "Seconds allowed to receive the request body, answered with 408 (default: global client limit, -1 no limit). Not applied to WebSocket upgrades.",
+                    "type": "integer",
+                    "example": 60
+                },
+                "redirect_code": {
+                    "description": "Redirect rules: 301, 302, 307 or 308 (default 302).",
+                    "type": "integer",
+                    "example": 302
+                },
+                "redirect_url": {
+                    "description": "Redirect rules: location template, supports {path}, {suffix}, {query}, {request_uri}, {host}, {scheme}, {rule_path} and regex groups ($1).",
+                    "type": "string",
+                    "example": "https://example.com{suffix}"
+                },
+                "request_headers": {
+                    "description": "Header operations applied to the request sent upstream.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.HeaderOps"
+                        }
+                    ]
+                },
+                "response_header_timeout": {
+                    "description": "Proxy rules: seconds to wait for upstream response headers (default 10, -1 waits forever).",
+                    "type": "integer",
+                    "example": 10
+                },
+                "response_headers": {
+                    "description": "Header operations applied to the response sent to the client.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.HeaderOps"
+                        }
+                    ]
+                },
                 "rewrite_html": {
                     "description": "If true, rewrites absolute paths in HTML response to include Path prefix.",
                     "type": "boolean",
                     "example": true
                 },
+                "rewrite_regex": {
+                    "description": "Regex applied to the request path before forwarding. Takes precedence over StripPath when it matches.",
+                    "type": "string",
+                    "example": "^/app/(.*)$"
+                },
+                "rewrite_target": {
+                    "description": "Replacement for RewriteRegex, supports $1 / ${name} group references.",
+                    "type": "string",
+                    "example": "/v2/$1"
+                },
+                "root": {
+                    "description": "Files rules: absolute directory served under Path.",
+                    "type": "string",
+                    "example": "/var/www/app"
+                },
+                "spa_fallback": {
+                    "description": "Files rules: serve Root/index.html for missing page requests.",
+                    "type": "boolean",
+                    "example": true
+                },
+                "status_code": {
+                    "description": "Static and maintenance rules: response status (default 200 and 503).",
+                    "type": "integer",
+                    "example": 200
+                },
                 "strip_path": {
                     "description": "If true, strips the Path prefix from the request before forwarding.",
                     "type": "boolean",
                     "example": true
                 },
                 "target": {
-                    "description": "Target URL (e.g., \"http://localhost:7996\")",
+                    "description": "Target URL (e.g., \"http://localhost:7996\"). Only used by proxy rules.",
                     "type": "string",
                     "example": "http://localhost:8080"
                 },
+                "timeout": {
+                    "description": "Proxy rules: total request timeout in seconds including the body (default 0, no limit). Not applied to WebSocket upgrades.",
+                    "type": "integer",
+                    "example": 0
+                },
+                "tls": {
+                    "description": "TLS settings for https:// and wss:// targets.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.UpstreamTLS"
+                        }
+                    ]
+                },
+                "type": {
+                    "description": "Rule type: \"proxy\" (default), \"redirect\", \"static\", \"maintenance\" or \"files\".",
+                    "type": "string",
+                    "example": "proxy"
+                },
+                "upstream_prefix": {
+                    "description": "Prefix added to the upstream path after stripping or rewriting.",
+                    "type": "string",
+                    "example": "/v2"
+                },
                 "use_auth": {
                     "description": "If true, invokes global authentication check before proxying.",
                     "type": "boolean",
@@ -978,15 +2468,200 @@ const docTemplate = `{
                 }
             }
         },
+        "models.TrustedProxy": {
+            "type": "object",
+            "properties": {
+                "cidr": {
+                    "description": "Source address or network of the proxy / load balancer",
+                    "type": "string",
+                    "example": "10.0.0.0/8"
+                },
+                "proxy_protocol": {
+                    "description": "PROXY protocol header policy: \"use\" (default, honored if present), \"require\", \"ignore\" (accepted but not honored) or \"reject\"",
+                    "type": "string",
+                    "example": "use"
+                },
+                "trust_headers": {
+                    "description": "If true, X-Forwarded-For / X-Real-IP sent from this network are honored",
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "models.TrustedProxyConfig": {
+            "type": "object",
+            "properties": {
+                "proxies": {
+                    "description": "Trusted networks, the most specific match wins",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TrustedProxy"
+                    }
+                },
+                "proxy_protocol": {
+                    "description": "PROXY protocol header policy for all other sources: \"ignore\" (default) or \"reject\"",
+                    "type": "string",
+                    "example": "ignore"
+                }
+            }
+        },
+        "models.UpstreamTLS": {
+            "type": "object",
+            "properties": {
+                "ca": {
+                    "description": "PEM CA bundle trusted for the upstream certificate (replaces system roots).",
+                    "type": "string",
+                    "example": "-----BEGIN CERTIFICATE-----\n..."
+                },
+                "cert": {
+                    "description": "PEM client certificate presented to the upstream (mTLS).",
+                    "type": "string"
+                },
+                "insecure_skip_verify": {
+                    "description": "Disable upstream certificate verification.",
+                    "type": "boolean",
+                    "example": false
+                },
+                "key": {
+                    "description": "PEM private key for Cert. Never returned; omit it to keep the stored key for an unchanged cert.",
+                    "type": "string"
+                },
+                "server_name": {
+                    "description": "SNI and verification name, defaults to the target host.",
+                    "type": "string",
+                    "example": "app.internal"
+                }
+            }
+        },
+        "proxy.ConcurrencyStats": {
+            "type": "object",
+            "properties": {
+                "admitted": {
+                    "description": "Requests that got a slot",
+                    "type": "integer"
+                },
+                "avg_wait_ms": {
+                    "description": "Average queue wait of requests that waited",
+                    "type": "number"
+                },
+                "in_flight": {
+                    "type": "integer"
+                },
+                "max_in_flight": {
+                    "type": "integer"
+                },
+                "max_queue": {
+                    "type": "integer"
+                },
+                "max_wait_ms": {
+                    "type": "number"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "port": {
+                    "description": "Listener port of the rule, 0 for the main proxy port",
+                    "type": "integer"
+                },
+                "queue_timeout": {
+                    "type": "integer"
+                },
+                "queued": {
+                    "description": "Requests waiting right now",
+                    "type": "integer"
+                },
+                "rejected": {
+                    "description": "Requests answered with 503 because the queue was full",
+                    "type": "integer"
+                },
+                "timed_out": {
+                    "description": "Requests answered with 503 after queue_timeout",
+                    "type": "integer"
+                },
+                "waited": {
+                    "description": "Admitted requests that had to queue first",
+                    "type": "integer"
+                }
+            }
+        },
+        "proxy.RateLimitStats": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "integer"
+                },
+                "burst": {
+                    "type": "integer"
+                },
+                "clients": {
+                    "description": "Clients with a partially used bucket",
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "limited_clients": {
+                    "description": "Clients whose bucket is currently empty",
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "port": {
+                    "description": "Listener port of the rule, 0 for the main proxy port",
+                    "type": "integer"
+                },
+                "rate": {
+                    "type": "number"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "scope": {
+                    "description": "\"global\" or \"rule\"",
+                    "type": "string"
+                }
+            }
+        },
         "proxy.TrafficStats": {
             "type": "object",
             "properties": {
                 "active_conns": {
                     "type": "integer"
                 },
+                "body_too_large": {
+                    "type": "integer"
+                },
                 "error_5xx": {
                     "type": "integer"
                 },
+                "geo_denied": {
+                    "description": "Requests rejected by a rule GeoIP filter",
+                    "type": "integer"
+                },
+                "headers_too_large": {
+                    "type": "integer"
+                },
+                "ip_denied": {
+                    "description": "Requests rejected by the global or a rule IP filter",
+                    "type": "integer"
+                },
+                "overloaded": {
+                    "type": "integer"
+                },
+                "queued": {
+                    "type": "integer"
+                },
+                "rate_limited": {
+                    "type": "integer"
+                },
+                "read_timeouts": {
+                    "type": "integer"
+                },
+                "rejected_conns": {
+                    "description": "Connections closed by max_conns_per_ip",
+                    "type": "integer"
+                },
                 "total_in": {
                     "type": "integer"
                 },
@@ -995,6 +2670,30 @@ const docTemplate = `{
                 }
             }
         },
+        "proxy.TransportStats": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "integer"
+                },
+                "dials": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string",
+                    "example": "http://127.0.0.1:8080"
+                },
+                "open_conns": {
+                    "type": "integer"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "reused": {
+                    "type": "integer"
+                }
+            }
+        },
         "response.Response": {
             "type": "object",
             "properties": {
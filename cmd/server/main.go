@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"flag"
@@ -15,6 +16,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,32 +39,144 @@ import (
 
 type proxyStack struct {
 	mu          sync.Mutex
-	host        string
-	listenAddr  string
+	hosts       []string
+	listenAddrs []string
 	proxyPort   int
+	defaultBind []string
 	handler     *proxy.Handler
 	httpServer  *http.Server
 	httpsServer *http.Server
+	httpConns   *connTracker
+	httpsConns  *connTracker
 
-	stop     func()
-	rebindCh chan struct{}
+	stop      func()
+	rebindCh  chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func newProxyStack(proxyPort int, handler *proxy.Handler, httpServer *http.Server, httpsServer *http.Server) *proxyStack {
-	return &proxyStack{
+type connTracker struct {
+	m sync.Map
+}
+
+func (ct *connTracker) track(c net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		ct.m.Delete(c)
+		return
+	}
+	ct.m.Store(c, state)
+}
+
+func (ct *connTracker) closeIdle() {
+	ct.m.Range(func(key, value any) bool {
+		if state, ok := value.(http.ConnState); ok && state == http.StateIdle {
+			_ = key.(net.Conn).Close()
+		}
+		return true
+	})
+}
+
+// newProxyStack builds the HTTP and HTTPS servers of one proxy port. The
+// certificate and PROXY protocol policy are looked up per port, so the main
+// port and additional listeners share the same code path.
+func newProxyStack(proxyPort int, defaultBind []string, handler *proxy.Handler, serve http.Handler) *proxyStack {
+	s := &proxyStack{
 		proxyPort:   proxyPort,
+		defaultBind: defaultBind,
 		handler:     handler,
-		httpServer:  httpServer,
-		httpsServer: httpsServer,
+		httpConns:   &connTracker{},
+		httpsConns:  &connTracker{},
 		rebindCh:    make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	logged := middleware.Logger(serve)
+	s.httpsServer = &http.Server{
+		Handler:           logged,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnState:         s.httpsConns.track,
+		TLSConfig: &tls.Config{
+			NextProtos:             []string{"h2", "http/1.1"},
+			SessionTicketsDisabled: true,
+			GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				cert := handler.GetListenerSSLCertificate(proxyPort)
+				if cert == nil {
+					return nil, fmt.Errorf("SSL not enabled")
+				}
+				return cert, nil
+			},
+		},
 	}
+
+	s.httpServer = &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnState:         s.httpConns.track,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if handler.GetListenerSSLCertificate(proxyPort) != nil {
+				target := "https://" + r.Host + r.URL.String()
+				http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+				return
+			}
+			logged.ServeHTTP(w, r)
+		}),
+	}
+	return s
+}
+
+func (s *proxyStack) desiredHosts() []string {
+	hosts := s.handler.GetListenerBind(s.proxyPort)
+	if len(hosts) == 0 {
+		hosts = s.defaultBind
+	}
+	if s.handler.GetListenerProxyProtocolForce(s.proxyPort) {
+		return loopbackHosts(hosts)
+	}
+	return hosts
+}
+
+// loopbackHosts maps bind addresses to the loopback address of the same
+// family. A dual-stack wildcard keeps both families.
+func loopbackHosts(hosts []string) []string {
+	var v4, v6 bool
+	for _, host := range hosts {
+		ip := net.ParseIP(host)
+		switch {
+		case ip.To4() != nil:
+			v4 = true
+		case ip.IsUnspecified():
+			v4, v6 = true, true
+		default:
+			v6 = true
+		}
+	}
+	var loopback []string
+	if v4 {
+		loopback = append(loopback, "127.0.0.1")
+	}
+	if v6 {
+		loopback = append(loopback, "::1")
+	}
+	return loopback
 }
 
-func (s *proxyStack) desiredHost() string {
-	if s.handler.GetProxyProtocolForce() {
-		return "127.0.0.1"
+// listenNetwork picks the network for a bind address. "::" is dual-stack
+// unless IPv4 addresses are bound separately.
+func listenNetwork(host string, hosts []string) string {
+	ip := net.ParseIP(host)
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	if ip.IsUnspecified() {
+		for _, other := range hosts {
+			if net.ParseIP(other).To4() != nil {
+				return "tcp6"
+			}
+		}
+		return "tcp"
 	}
-	return "0.0.0.0"
+	return "tcp6"
 }
 
 func (s *proxyStack) Start() error {
@@ -70,9 +184,14 @@ func (s *proxyStack) Start() error {
 		return err
 	}
 	go func() {
-		for range s.rebindCh {
-			if err := s.rebind(); err != nil {
-				log.Printf("Failed to rebind proxy listener: %v", err)
+		for {
+			select {
+			case <-s.rebindCh:
+				if err := s.rebind(); err != nil {
+					log.Printf("Failed to rebind proxy listener: %v", err)
+				}
+			case <-s.done:
+				return
 			}
 		}
 	}()
@@ -86,6 +205,11 @@ func (s *proxyStack) RequestRebind() {
 	}
 }
 
+func (s *proxyStack) CloseIdle() {
+	s.httpsConns.closeIdle()
+	s.httpConns.closeIdle()
+}
+
 func (s *proxyStack) Stop() {
 	s.mu.Lock()
 	stop := s.stop
@@ -96,18 +220,57 @@ func (s *proxyStack) Stop() {
 	}
 }
 
-func (s *proxyStack) ListenAddr() string {
+// Close stops the stack for good, including connections still in use.
+func (s *proxyStack) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.Stop()
+	_ = s.httpServer.Close()
+	_ = s.httpsServer.Close()
+}
+
+// Shutdown stops accepting connections, tells keep-alive clients to close
+// and waits for requests in flight until ctx expires.
+func (s *proxyStack) Shutdown(ctx context.Context) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.httpServer.SetKeepAlivesEnabled(false)
+	s.httpsServer.SetKeepAlivesEnabled(false)
+	s.Stop()
+
+	var wg sync.WaitGroup
+	for _, srv := range []*http.Server{s.httpServer, s.httpsServer} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("Proxy server shutdown failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *proxyStack) ListenAddrs() []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.listenAddr
+	return s.listenAddrs
 }
 
 func (s *proxyStack) rebind() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	desiredHost := s.desiredHost()
-	if s.host == desiredHost && s.stop != nil {
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+
+	desiredHosts := s.desiredHosts()
+	if slices.Equal(s.hosts, desiredHosts) && s.stop != nil {
 		return nil
 	}
 
@@ -116,14 +279,14 @@ func (s *proxyStack) rebind() error {
 		s.stop = nil
 	}
 
-	stop, listenAddr, err := startProxyServers(desiredHost, s.proxyPort, s.handler, s.httpServer, s.httpsServer)
+	stop, listenAddrs, err := startProxyServers(desiredHosts, s.proxyPort, s.handler, s.httpServer, s.httpsServer)
 	if err != nil {
 		return err
 	}
-	s.host = desiredHost
+	s.hosts = desiredHosts
 	s.stop = stop
-	s.listenAddr = listenAddr
-	log.Printf("Reverse Proxy listening on %s", listenAddr)
+	s.listenAddrs = listenAddrs
+	log.Printf("Reverse Proxy listening on %s", strings.Join(listenAddrs, ", "))
 	return nil
 }
 
@@ -131,73 +294,198 @@ func isClosedConnErr(err error) bool {
 	if err == nil {
 		return false
 	}
-	if errors.Is(err, net.ErrClosed) {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, cmux.ErrServerClosed) {
 		return true
 	}
 	return strings.Contains(err.Error(), "use of closed network connection")
 }
 
-func startProxyServers(host string, proxyPort int, proxyHandler *proxy.Handler, httpServer *http.Server, httpsServer *http.Server) (func(), string, error) {
-	addr := net.JoinHostPort(host, strconv.Itoa(proxyPort))
-	tcpListener, err := net.Listen("tcp4", addr)
-	if err != nil {
-		return nil, "", err
+func startProxyServers(hosts []string, proxyPort int, handler *proxy.Handler, httpServer *http.Server, httpsServer *http.Server) (func(), []string, error) {
+	var listeners []net.Listener
+	var listenAddrs []string
+	for _, host := range hosts {
+		addr := net.JoinHostPort(host, strconv.Itoa(proxyPort))
+		tcpListener, err := sockets.Listen(listenNetwork(host, hosts), addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, nil, err
+		}
+		listeners = append(listeners, &proxyproto.Listener{
+			Listener:   handler.LimitConnections(tcpListener, proxyPort),
+			ConnPolicy: handler.ProxyProtocolPolicy(proxyPort),
+		})
+		listenAddrs = append(listenAddrs, tcpListener.Addr().String())
+	}
+
+	var wg sync.WaitGroup
+	for _, proxyListener := range listeners {
+		m := cmux.New(proxyListener)
+		tlsL := m.Match(cmux.TLS())
+		httpL := m.Match(cmux.HTTP1Fast(), cmux.HTTP2())
+
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			err := httpsServer.Serve(tls.NewListener(tlsL, httpsServer.TLSConfig))
+			if err != nil && err != http.ErrServerClosed && !isClosedConnErr(err) {
+				log.Printf("HTTPS server failed: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			err := httpServer.Serve(httpL)
+			if err != nil && err != http.ErrServerClosed && !isClosedConnErr(err) {
+				log.Printf("HTTP server failed: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			err := m.Serve()
+			if err != nil && !isClosedConnErr(err) {
+				log.Printf("cmux server failed: %v", err)
+			}
+		}()
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			wg.Wait()
+		})
+	}
+
+	return stop, listenAddrs, nil
+}
+
+// listenerSet runs one proxyStack per additional listener and keeps them in
+// line with the handler's listener config.
+type listenerSet struct {
+	mu          sync.Mutex
+	defaultBind []string
+	handler     *proxy.Handler
+	stacks      map[int]*proxyStack
+}
+
+func newListenerSet(defaultBind []string, handler *proxy.Handler) *listenerSet {
+	return &listenerSet{
+		defaultBind: defaultBind,
+		handler:     handler,
+		stacks:      make(map[int]*proxyStack),
 	}
+}
+
+func (l *listenerSet) Sync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	desired := make(map[int]bool)
+	for _, port := range l.handler.GetListenerPorts() {
+		desired[port] = true
+		if stack, ok := l.stacks[port]; ok {
+			stack.CloseIdle()
+			stack.RequestRebind()
+			continue
+		}
+		stack := newProxyStack(port, l.defaultBind, l.handler, l.handler.ListenerHandler(port))
+		if err := stack.Start(); err != nil {
+			log.Printf("Failed to start listener on port %d: %v", port, err)
+			continue
+		}
+		l.stacks[port] = stack
+	}
+
+	for port, stack := range l.stacks {
+		if !desired[port] {
+			stack.Close()
+			delete(l.stacks, port)
+			log.Printf("Reverse Proxy listener on port %d stopped", port)
+		}
+	}
+}
 
-	proxyListener := &proxyproto.Listener{
-		Listener: tcpListener,
+func (l *listenerSet) CloseIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, stack := range l.stacks {
+		stack.CloseIdle()
 	}
+}
 
-	m := cmux.New(proxyListener)
-	tlsL := m.Match(cmux.TLS())
-	httpL := m.Match(cmux.HTTP1Fast(), cmux.HTTP2())
+func (l *listenerSet) Shutdown(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	var wg sync.WaitGroup
-	wg.Add(3)
+	for _, stack := range l.stacks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stack.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+}
 
+// drain shuts every server down gracefully. Upgraded connections such as
+// WebSockets are not tracked by http.Server, so the handler's active request
+// count is awaited as well.
+func drain(ctx context.Context, adminServer *admin.Server, proxyStack *proxyStack, listeners *listenerSet, handler *proxy.Handler) {
+	var wg sync.WaitGroup
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
-		err := httpsServer.Serve(tls.NewListener(tlsL, httpsServer.TLSConfig))
-		if err != nil && err != http.ErrServerClosed && !isClosedConnErr(err) {
-			log.Printf("HTTPS server failed: %v", err)
+		if err := adminServer.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Admin server shutdown failed: %v", err)
 		}
 	}()
-
 	go func() {
 		defer wg.Done()
-		err := httpServer.Serve(httpL)
-		if err != nil && err != http.ErrServerClosed && !isClosedConnErr(err) {
-			log.Printf("HTTP server failed: %v", err)
-		}
+		proxyStack.Shutdown(ctx)
 	}()
-
 	go func() {
 		defer wg.Done()
-		err := m.Serve()
-		if err != nil && !isClosedConnErr(err) {
-			log.Printf("cmux server failed: %v", err)
-		}
+		listeners.Shutdown(ctx)
 	}()
-
-	var once sync.Once
-	stop := func() {
-		once.Do(func() {
-			_ = proxyListener.Close()
-			wg.Wait()
-		})
+	wg.Wait()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for handler.ActiveRequests() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Drain timeout reached with %d active requests", handler.ActiveRequests())
+			return
+		case <-ticker.C:
+		}
 	}
-
-	return stop, tcpListener.Addr().String(), nil
 }
 
 func main() {
 	adminPort := flag.Int("admin-port", 7996, "Port for the Admin API (0 uses config or default 7996, binds to 127.0.0.1)")
-	proxyPort := flag.Int("proxy-port", 7999, "Port for the Reverse Proxy (binds to 0.0.0.0 or 127.0.0.1 based on proxy_protocol_force)")
+	proxyPort := flag.Int("proxy-port", 7999, "Port for the Reverse Proxy (binds to -proxy-bind, or loopback when proxy_protocol_force is on)")
+	adminBind := flag.String("admin-bind", "", "Bind address for the Admin API (empty uses config or default 127.0.0.1)")
+	proxyBind := flag.String("proxy-bind", "", "Comma-separated bind addresses for the Reverse Proxy, e.g. 0.0.0.0,:: (empty uses config or default 0.0.0.0; \"::\" alone is dual-stack)")
+	drainTimeoutFlag := flag.Int("drain-timeout", 0, "Seconds to wait for in-flight requests on shutdown or upgrade (0 uses config or default 30)")
 	configFlag := flag.String("c", "", "Path to config file (default: config.json in executable directory)")
 	flag.Parse()
 
 	log.Printf("Starting Go Reauth Proxy Service...")
 
+	if err := sockets.loadInherited(); err != nil {
+		log.Fatalf("Failed to load inherited listeners: %v", err)
+	}
+	if err := sockets.loadActivated(); err != nil {
+		log.Fatalf("Failed to load systemd sockets: %v", err)
+	}
+
 	execPath, err := os.Executable()
 	if err != nil {
 		log.Fatalf("Failed to get executable path: %v", err)
@@ -248,98 +536,129 @@ func main() {
 		}
 	}
 
+	resolvedAdminBind := *adminBind
+	if resolvedAdminBind == "" {
+		resolvedAdminBind = initialCfg.AdminBind
+		if resolvedAdminBind == "" {
+			resolvedAdminBind = "127.0.0.1"
+		}
+	}
+	adminHosts, err := proxy.NormalizeBindAddresses([]string{resolvedAdminBind})
+	if err != nil {
+		log.Fatalf("Invalid admin bind address: %v", err)
+	}
+
+	proxyBindAddrs := initialCfg.ProxyBind
+	if *proxyBind != "" {
+		proxyBindAddrs = strings.Split(*proxyBind, ",")
+	}
+	if len(proxyBindAddrs) == 0 {
+		proxyBindAddrs = []string{"0.0.0.0"}
+	}
+	proxyHosts, err := proxy.NormalizeBindAddresses(proxyBindAddrs)
+	if err != nil {
+		log.Fatalf("Invalid proxy bind address: %v", err)
+	}
+
+	drainTimeout := *drainTimeoutFlag
+	if drainTimeout <= 0 {
+		drainTimeout = initialCfg.DrainTimeout
+		if drainTimeout <= 0 {
+			drainTimeout = 30
+		}
+	}
+
 	proxyHandler := proxy.NewHandler(resolvedAdminPort, cfgManager, initialCfg)
+	proxyHandler.SetAdminBind(adminHosts)
 
 	currentConfig := proxyHandler.GetAuthConfig()
 	proxyHandler.SetAuthConfig(currentConfig)
 
-	adminServer := admin.NewServer(proxyHandler, resolvedAdminPort, cfgManager, initialCfg)
+	adminServer := admin.NewServer(proxyHandler, adminHosts[0], resolvedAdminPort, cfgManager, initialCfg)
+	adminListener, err := sockets.Listen("tcp", adminServer.Addr())
+	if err != nil {
+		log.Fatalf("Admin server failed: %v", err)
+	}
 	go func() {
-		if err := adminServer.Start(); err != nil {
+		if err := adminServer.Serve(adminListener); err != nil {
 			log.Fatalf("Admin server failed: %v", err)
 		}
 	}()
 
-	httpsServer := &http.Server{
-		Handler:           middleware.Logger(proxyHandler),
-		ReadHeaderTimeout: 10 * time.Second,
-		IdleTimeout:       120 * time.Second,
-		TLSConfig: &tls.Config{
-			NextProtos:             []string{"h2", "http/1.1"},
-			SessionTicketsDisabled: true,
-			GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
-				cert := proxyHandler.GetSSLCertificate()
-				if cert == nil {
-					return nil, fmt.Errorf("SSL not enabled")
-				}
-				return cert, nil
-			},
-		},
-	}
+	proxyHandler.SetProxyPort(*proxyPort)
 
-	httpServer := &http.Server{
-		ReadHeaderTimeout: 10 * time.Second,
-		IdleTimeout:       120 * time.Second,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if proxyHandler.GetSSLCertificate() != nil {
-				target := "https://" + r.Host + r.URL.String()
-				http.Redirect(w, r, target, http.StatusTemporaryRedirect)
-				return
-			}
-			middleware.Logger(proxyHandler).ServeHTTP(w, r)
-		}),
+	proxyStack := newProxyStack(*proxyPort, proxyHosts, proxyHandler, proxyHandler)
+	if err := proxyStack.Start(); err != nil {
+		log.Fatalf("Failed to start proxy stack: %v", err)
 	}
 
-	type connTracker struct {
-		m sync.Map
-	}
+	listeners := newListenerSet(proxyHosts, proxyHandler)
+	listeners.Sync()
 
-	closeIdle := func(ct *connTracker) {
-		ct.m.Range(func(key, value any) bool {
-			if state, ok := value.(http.ConnState); ok && state == http.StateIdle {
-				_ = key.(net.Conn).Close()
-			}
-			return true
-		})
-	}
-
-	httpsConns := &connTracker{}
-	httpConns := &connTracker{}
-	httpsServer.ConnState = func(c net.Conn, state http.ConnState) {
-		if state == http.StateClosed || state == http.StateHijacked {
-			httpsConns.m.Delete(c)
+	proxyHandler.SetSSLChangeHook(func() {
+		proxyStack.CloseIdle()
+		listeners.CloseIdle()
+	})
+	proxyHandler.SetProxyProtocolForceChangeHook(func() {
+		proxyStack.CloseIdle()
+		proxyStack.RequestRebind()
+	})
+	proxyHandler.SetListenersChangeHook(listeners.Sync)
+
+	var geoBlocksMu sync.Mutex
+	syncGeoBlocks := func() {
+		geoBlocksMu.Lock()
+		defer geoBlocksMu.Unlock()
+		networks, err := proxyHandler.GeoIPBlockedNetworks()
+		if err != nil {
+			log.Printf("Failed to resolve GeoIP country blocks: %v", err)
 			return
 		}
-		httpsConns.m.Store(c, state)
-	}
-	httpServer.ConnState = func(c net.Conn, state http.ConnState) {
-		if state == http.StateClosed || state == http.StateHijacked {
-			httpConns.m.Delete(c)
-			return
+		if err := adminServer.IptablesHandler.Manager.SetGeoBlocks(networks); err != nil {
+			log.Printf("Failed to apply GeoIP country blocks: %v", err)
 		}
-		httpConns.m.Store(c, state)
 	}
-
-	proxyHandler.SetSSLChangeHook(func() {
-		closeIdle(httpsConns)
-		closeIdle(httpConns)
-	})
-
-	proxyStack := newProxyStack(*proxyPort, proxyHandler, httpServer, httpsServer)
-	if err := proxyStack.Start(); err != nil {
-		log.Fatalf("Failed to start proxy stack: %v", err)
+	proxyHandler.SetGeoIPChangeHook(func() { go syncGeoBlocks() })
+	if len(proxyHandler.GetGeoIP().IptablesBlockCountries) > 0 {
+		go syncGeoBlocks()
 	}
 
-	proxyHandler.SetProxyProtocolForceChangeHook(func() {
-		closeIdle(httpsConns)
-		closeIdle(httpConns)
-		proxyStack.RequestRebind()
-	})
+	sockets.closeUnused()
+	// After an upgrade the parent reports the new main pid to systemd.
+	if !notifyUpgradeReady() {
+		sdNotify("READY=1")
+	}
+	stopWatchdog := startWatchdog()
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	upgraded := false
+	for {
+		sig := <-quit
+		if sig != syscall.SIGUSR2 {
+			break
+		}
+		log.Println("Starting binary upgrade...")
+		sdNotifyReloading()
+		pid, err := startUpgrade()
+		if err != nil {
+			log.Printf("Binary upgrade failed: %v", err)
+			sdNotify("READY=1")
+			continue
+		}
+		stopWatchdog()
+		sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", pid))
+		upgraded = true
+		break
+	}
+	if !upgraded {
+		sdNotify("STOPPING=1")
+	}
 
-	log.Println("Shutting down...")
-	proxyStack.Stop()
+	timeout := time.Duration(drainTimeout) * time.Second
+	log.Printf("Shutting down, draining connections for up to %s...", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	drain(ctx, adminServer, proxyStack, listeners, proxyHandler)
+	log.Println("Shutdown complete")
 }
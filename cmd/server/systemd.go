@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket activation and readiness notification as described in
+// sd_listen_fds(3) and sd_notify(3).
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envNotifySocket  = "NOTIFY_SOCKET"
+	envWatchdogUSec  = "WATCHDOG_USEC"
+	envWatchdogPID   = "WATCHDOG_PID"
+	listenFDsStart   = 3
+)
+
+// loadActivated picks up sockets passed by systemd. They are matched to
+// listen addresses by their local address rather than by order.
+func (r *listenerRegistry) loadActivated() error {
+	pid, count, names := os.Getenv(envListenPID), os.Getenv(envListenFDs), os.Getenv(envListenFDNames)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+	if count == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid %s %q", envListenFDs, count)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < n; i++ {
+		fd := r.fdStart + i
+		unix.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("activated socket %s: %v", name, err)
+		}
+		addr := l.Addr().String()
+		log.Printf("Using socket %s from systemd for %s", name, addr)
+		r.activated[addr] = l
+	}
+	return nil
+}
+
+// takeActivatedLocked returns the activated socket for addr. A socket bound
+// to a wildcard address also serves a wildcard bind of the other family, so
+// ListenStream=80 works with the default bind addresses.
+func (r *listenerRegistry) takeActivatedLocked(addr string) (net.Listener, bool) {
+	if l, ok := r.activated[addr]; ok {
+		delete(r.activated, addr)
+		return l, true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+		return nil, false
+	}
+	for key, l := range r.activated {
+		tcpAddr, ok := l.Addr().(*net.TCPAddr)
+		if ok && tcpAddr.IP.IsUnspecified() && strconv.Itoa(tcpAddr.Port) == port {
+			delete(r.activated, key)
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// sdNotify sends a state update to the service manager. It does nothing when
+// the process was not started with NOTIFY_SOCKET.
+func sdNotify(state string) {
+	socketPath := os.Getenv(envNotifySocket)
+	if socketPath == "" {
+		return
+	}
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		log.Printf("sd_notify failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("sd_notify failed: %v", err)
+	}
+}
+
+func sdNotifyReloading() {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		sdNotify("RELOADING=1")
+		return
+	}
+	sdNotify(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", ts.Nano()/int64(time.Microsecond)))
+}
+
+// startWatchdog pings the service manager at half the watchdog interval until
+// the returned function is called.
+func startWatchdog() func() {
+	usec, err := strconv.ParseInt(os.Getenv(envWatchdogUSec), 10, 64)
+	if err != nil || usec <= 0 {
+		return func() {}
+	}
+	if pid := os.Getenv(envWatchdogPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return func() {}
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	log.Printf("systemd watchdog enabled, pinging every %s", interval)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sdNotify("WATCHDOG=1")
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
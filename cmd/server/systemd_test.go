@@ -0,0 +1,281 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testFDStart is where tests place passed descriptors, well above anything
+// the test binary opens itself.
+const testFDStart = 400
+
+// passFDs moves files to consecutive descriptors from testFDStart, as a
+// service manager or upgrading parent would.
+func passFDs(t *testing.T, files ...*os.File) {
+	t.Helper()
+	for i, f := range files {
+		fd := testFDStart + i
+		if err := unix.Dup3(int(f.Fd()), fd, 0); err != nil {
+			t.Fatalf("dup3 to %d: %v", fd, err)
+		}
+		f.Close()
+		t.Cleanup(func() { unix.Close(fd) })
+	}
+}
+
+func listenerFile(t *testing.T, addr string) (*os.File, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, l.Addr().String()
+}
+
+func fdOpen(fd int) bool {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	return err == nil
+}
+
+func newTestRegistry() *listenerRegistry {
+	r := newListenerRegistry()
+	r.fdStart = testFDStart
+	return r
+}
+
+func setActivationEnv(t *testing.T, pid, fds, names string) {
+	t.Setenv(envListenPID, pid)
+	t.Setenv(envListenFDs, fds)
+	t.Setenv(envListenFDNames, names)
+}
+
+func TestLoadActivated(t *testing.T) {
+	web, webAddr := listenerFile(t, "127.0.0.1:0")
+	admin, adminAddr := listenerFile(t, "127.0.0.1:0")
+	passFDs(t, web, admin)
+	setActivationEnv(t, strconv.Itoa(os.Getpid()), "2", "web:admin")
+
+	r := newTestRegistry()
+	if err := r.loadActivated(); err != nil {
+		t.Fatalf("loadActivated: %v", err)
+	}
+	for _, name := range []string{envListenPID, envListenFDs, envListenFDNames} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s was not unset", name)
+		}
+	}
+	if len(r.activated) != 2 || r.activated[webAddr] == nil || r.activated[adminAddr] == nil {
+		t.Fatalf("activated = %v, want %s and %s", r.activated, webAddr, adminAddr)
+	}
+
+	l, err := r.Listen("tcp", webAddr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	if got := l.Addr().String(); got != webAddr {
+		t.Fatalf("Listen returned %s, want the activated socket %s", got, webAddr)
+	}
+	conn, err := net.Dial("tcp", webAddr)
+	if err != nil {
+		t.Fatalf("dial activated socket: %v", err)
+	}
+	conn.Close()
+
+	r.mu.Lock()
+	_, again := r.takeActivatedLocked(webAddr)
+	r.mu.Unlock()
+	if again {
+		t.Fatal("activated socket was handed out twice")
+	}
+
+	r.closeUnused()
+	if len(r.activated) != 0 {
+		t.Fatalf("closeUnused left %v", r.activated)
+	}
+	if _, err := net.Dial("tcp", adminAddr); err == nil {
+		t.Fatal("unused activated socket is still accepting")
+	}
+}
+
+func TestLoadActivatedIgnoresOtherPID(t *testing.T) {
+	f, _ := listenerFile(t, "127.0.0.1:0")
+	passFDs(t, f)
+	setActivationEnv(t, strconv.Itoa(os.Getpid()+1), "1", "")
+
+	r := newTestRegistry()
+	if err := r.loadActivated(); err != nil {
+		t.Fatalf("loadActivated: %v", err)
+	}
+	if len(r.activated) != 0 {
+		t.Fatalf("activated = %v, want none", r.activated)
+	}
+	if !fdOpen(testFDStart) {
+		t.Fatal("descriptor meant for another process was consumed")
+	}
+	if _, ok := os.LookupEnv(envListenFDs); ok {
+		t.Errorf("%s was not unset", envListenFDs)
+	}
+}
+
+func TestLoadActivatedWithoutSockets(t *testing.T) {
+	setActivationEnv(t, "", "", "")
+	r := newTestRegistry()
+	if err := r.loadActivated(); err != nil {
+		t.Fatalf("loadActivated: %v", err)
+	}
+	if len(r.activated) != 0 {
+		t.Fatalf("activated = %v, want none", r.activated)
+	}
+}
+
+func TestLoadActivatedMalformed(t *testing.T) {
+	for _, count := range []string{"abc", "-1", "1x"} {
+		t.Run(count, func(t *testing.T) {
+			setActivationEnv(t, strconv.Itoa(os.Getpid()), count, "")
+			if err := newTestRegistry().loadActivated(); err == nil || !strings.Contains(err.Error(), envListenFDs) {
+				t.Fatalf("loadActivated with %s=%q: err = %v", envListenFDs, count, err)
+			}
+		})
+	}
+
+	t.Run("not a socket", func(t *testing.T) {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pw.Close()
+		passFDs(t, pr)
+		setActivationEnv(t, strconv.Itoa(os.Getpid()), "1", "pipe")
+		if err := newTestRegistry().loadActivated(); err == nil || !strings.Contains(err.Error(), "activated socket pipe") {
+			t.Fatalf("loadActivated with a pipe: err = %v", err)
+		}
+	})
+}
+
+func TestTakeActivatedWildcard(t *testing.T) {
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	r := newListenerRegistry()
+	r.activated[l.Addr().String()] = l
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.takeActivatedLocked("127.0.0.1:" + port); ok {
+		t.Fatal("wildcard socket served a specific address")
+	}
+	if got, ok := r.takeActivatedLocked(net.JoinHostPort("::", port)); !ok || got != l {
+		t.Fatal("wildcard socket did not serve the wildcard bind of the other family")
+	}
+	if _, ok := r.takeActivatedLocked(net.JoinHostPort("::", port)); ok {
+		t.Fatal("activated socket was handed out twice")
+	}
+}
+
+func listenNotify(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn, timeout time.Duration) (string, bool) {
+	t.Helper()
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", false
+	}
+	return string(buf[:n]), true
+}
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listenNotify(t, path)
+	t.Setenv(envNotifySocket, path)
+
+	sdNotify("READY=1")
+	if got, ok := readNotify(t, conn, time.Second); !ok || got != "READY=1" {
+		t.Fatalf("received %q, want READY=1", got)
+	}
+
+	sdNotifyReloading()
+	if got, _ := readNotify(t, conn, time.Second); !strings.HasPrefix(got, "RELOADING=1\nMONOTONIC_USEC=") {
+		t.Fatalf("received %q, want RELOADING=1 with MONOTONIC_USEC", got)
+	}
+}
+
+func TestSdNotifyAbstractSocket(t *testing.T) {
+	name := "reauth-proxy-test-" + strconv.Itoa(os.Getpid())
+	conn := listenNotify(t, "\x00"+name)
+	t.Setenv(envNotifySocket, "@"+name)
+
+	sdNotify("STOPPING=1")
+	if got, ok := readNotify(t, conn, time.Second); !ok || got != "STOPPING=1" {
+		t.Fatalf("received %q, want STOPPING=1", got)
+	}
+}
+
+func TestSdNotifyWithoutSocket(t *testing.T) {
+	t.Setenv(envNotifySocket, "")
+	sdNotify("READY=1")
+}
+
+func TestStartWatchdog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listenNotify(t, path)
+	t.Setenv(envNotifySocket, path)
+	t.Setenv(envWatchdogUSec, "20000")
+
+	t.Run("other pid", func(t *testing.T) {
+		t.Setenv(envWatchdogPID, strconv.Itoa(os.Getpid()+1))
+		stop := startWatchdog()
+		defer stop()
+		if got, ok := readNotify(t, conn, 100*time.Millisecond); ok {
+			t.Fatalf("received %q for another process's watchdog", got)
+		}
+	})
+
+	t.Run("own pid", func(t *testing.T) {
+		t.Setenv(envWatchdogPID, strconv.Itoa(os.Getpid()))
+		stop := startWatchdog()
+		if got, ok := readNotify(t, conn, time.Second); !ok || got != "WATCHDOG=1" {
+			t.Fatalf("received %q, want WATCHDOG=1", got)
+		}
+		stop()
+		stop()
+	})
+
+	t.Run("malformed interval", func(t *testing.T) {
+		t.Setenv(envWatchdogUSec, "soon")
+		t.Setenv(envWatchdogPID, "")
+		stop := startWatchdog()
+		defer stop()
+		// Drain a ping the previous subtest may have sent while stopping.
+		readNotify(t, conn, 30*time.Millisecond)
+		if got, ok := readNotify(t, conn, 100*time.Millisecond); ok {
+			t.Fatalf("received %q without a valid %s", got, envWatchdogUSec)
+		}
+	})
+}
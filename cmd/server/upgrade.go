@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A binary upgrade hands the listening sockets to a re-executed child. The
+// child finds them through these variables and reports readiness by writing
+// to the pipe, after which the parent drains and exits.
+const (
+	envInheritedListeners = "REAUTH_PROXY_LISTENERS"
+	envUpgradeReadyFD     = "REAUTH_PROXY_READY_FD"
+	upgradeReadyTimeout   = 30 * time.Second
+)
+
+// listenerRegistry opens TCP listeners, reusing inherited or systemd
+// activated sockets when the address matches, and remembers every open
+// listener so it can be passed on during an upgrade.
+type listenerRegistry struct {
+	mu        sync.Mutex
+	inherited map[string]net.Listener
+	activated map[string]net.Listener
+	active    map[net.Listener]string
+	fdStart   int // first passed descriptor
+}
+
+var sockets = newListenerRegistry()
+
+func newListenerRegistry() *listenerRegistry {
+	return &listenerRegistry{
+		inherited: make(map[string]net.Listener),
+		activated: make(map[string]net.Listener),
+		active:    make(map[net.Listener]string),
+		fdStart:   listenFDsStart,
+	}
+}
+
+func listenerKey(network, addr string) string {
+	return network + ":" + addr
+}
+
+// loadInherited picks up sockets passed by a parent process. Descriptors
+// start at 3, in the order listed in REAUTH_PROXY_LISTENERS.
+func (r *listenerRegistry) loadInherited() error {
+	value := os.Getenv(envInheritedListeners)
+	os.Unsetenv(envInheritedListeners)
+	if value == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, key := range strings.Split(value, ",") {
+		f := os.NewFile(uintptr(r.fdStart+i), key)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("inherited listener %s: %v", key, err)
+		}
+		r.inherited[key] = l
+	}
+	return nil
+}
+
+func (r *listenerRegistry) Listen(network, addr string) (net.Listener, error) {
+	key := listenerKey(network, addr)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.inherited[key]
+	if ok {
+		delete(r.inherited, key)
+	} else {
+		// An activated socket keeps the requested key, which is what an
+		// upgraded child asks for.
+		l, ok = r.takeActivatedLocked(addr)
+	}
+	if !ok {
+		var err error
+		if l, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+		// Port 0 resolves to a real port, which is what a child must ask for.
+		key = listenerKey(network, l.Addr().String())
+	}
+	r.active[l] = key
+	return &registeredListener{Listener: l, registry: r}, nil
+}
+
+// closeUnused closes inherited and activated sockets the current config no
+// longer uses.
+func (r *listenerRegistry) closeUnused() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, l := range r.inherited {
+		log.Printf("Closing unused inherited listener %s", key)
+		_ = l.Close()
+		delete(r.inherited, key)
+	}
+	for addr, l := range r.activated {
+		log.Printf("Closing unused systemd socket %s, no proxy or admin address matches it", addr)
+		_ = l.Close()
+		delete(r.activated, addr)
+	}
+}
+
+func (r *listenerRegistry) files() ([]string, []*os.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []string
+	var files []*os.File
+	for l, key := range r.active {
+		tcp, ok := l.(*net.TCPListener)
+		if !ok {
+			continue
+		}
+		f, err := tcp.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	return keys, files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+type registeredListener struct {
+	net.Listener
+	registry *listenerRegistry
+	once     sync.Once
+}
+
+func (l *registeredListener) Close() error {
+	l.once.Do(func() {
+		l.registry.mu.Lock()
+		delete(l.registry.active, l.Listener)
+		l.registry.mu.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+// startUpgrade re-executes the binary with all open listeners and waits until
+// the child reports that it is serving. It returns the pid of the child; an
+// error means the parent should keep running.
+func startUpgrade() (int, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	keys, files, err := sockets.files()
+	if err != nil {
+		return 0, err
+	}
+	defer closeFiles(files)
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyR.Close()
+
+	// The watchdog belongs to whichever process is the main pid, so the child
+	// must not inherit a WATCHDOG_PID naming the parent.
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envWatchdogPID+"=") {
+			env = append(env, kv)
+		}
+	}
+
+	cmd := exec.Command(execPath, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(env,
+		envInheritedListeners+"="+strings.Join(keys, ","),
+		envUpgradeReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		if err == io.EOF {
+			err = fmt.Errorf("new process exited before it was ready")
+		}
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("New process %d is ready", cmd.Process.Pid)
+		return cmd.Process.Pid, nil
+	case <-time.After(upgradeReadyTimeout):
+		_ = cmd.Process.Kill()
+		return 0, fmt.Errorf("new process did not become ready within %s", upgradeReadyTimeout)
+	}
+}
+
+// notifyUpgradeReady tells the parent of a binary upgrade that this process
+// is serving. It reports whether this process was started by an upgrade.
+func notifyUpgradeReady() bool {
+	value := os.Getenv(envUpgradeReadyFD)
+	os.Unsetenv(envUpgradeReadyFD)
+	if value == "" {
+		return false
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return false
+	}
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	_, _ = f.Write([]byte{1})
+	_ = f.Close()
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLoadInherited(t *testing.T) {
+	proxy, proxyAddr := listenerFile(t, "127.0.0.1:0")
+	admin, adminAddr := listenerFile(t, "127.0.0.1:0")
+	passFDs(t, proxy, admin)
+	proxyKey, adminKey := listenerKey("tcp", proxyAddr), listenerKey("tcp", adminAddr)
+	t.Setenv(envInheritedListeners, proxyKey+","+adminKey)
+
+	r := newTestRegistry()
+	if err := r.loadInherited(); err != nil {
+		t.Fatalf("loadInherited: %v", err)
+	}
+	if _, ok := os.LookupEnv(envInheritedListeners); ok {
+		t.Errorf("%s was not unset", envInheritedListeners)
+	}
+	if len(r.inherited) != 2 {
+		t.Fatalf("inherited = %v, want %s and %s", r.inherited, proxyKey, adminKey)
+	}
+
+	// Descriptors are matched by position, so the admin socket must come
+	// back for the admin address.
+	l, err := r.Listen("tcp", adminAddr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	if got := l.Addr().String(); got != adminAddr {
+		t.Fatalf("Listen returned %s, want the inherited socket %s", got, adminAddr)
+	}
+	conn, err := net.Dial("tcp", adminAddr)
+	if err != nil {
+		t.Fatalf("dial inherited socket: %v", err)
+	}
+	conn.Close()
+	if _, ok := r.inherited[adminKey]; ok {
+		t.Fatal("inherited socket was not taken")
+	}
+
+	keys, files, err := r.files()
+	if err != nil {
+		t.Fatalf("files: %v", err)
+	}
+	closeFiles(files)
+	if !slices.Equal(keys, []string{adminKey}) {
+		t.Fatalf("files keys = %v, want [%s]", keys, adminKey)
+	}
+
+	r.closeUnused()
+	if _, err := net.Dial("tcp", proxyAddr); err == nil {
+		t.Fatal("unused inherited socket is still accepting")
+	}
+}
+
+func TestLoadInheritedWithoutListeners(t *testing.T) {
+	t.Setenv(envInheritedListeners, "")
+	r := newTestRegistry()
+	if err := r.loadInherited(); err != nil {
+		t.Fatalf("loadInherited: %v", err)
+	}
+	if len(r.inherited) != 0 {
+		t.Fatalf("inherited = %v, want none", r.inherited)
+	}
+}
+
+func TestLoadInheritedNotASocket(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pw.Close()
+	passFDs(t, pr)
+	t.Setenv(envInheritedListeners, "tcp:127.0.0.1:1")
+
+	if err := newTestRegistry().loadInherited(); err == nil || !strings.Contains(err.Error(), "inherited listener tcp:127.0.0.1:1") {
+		t.Fatalf("loadInherited with a pipe: err = %v", err)
+	}
+}
+
+func TestListenPortZeroKeepsResolvedAddress(t *testing.T) {
+	r := newTestRegistry()
+	l, err := r.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	keys, files, err := r.files()
+	if err != nil {
+		t.Fatalf("files: %v", err)
+	}
+	closeFiles(files)
+	if want := listenerKey("tcp", l.Addr().String()); !slices.Equal(keys, []string{want}) {
+		t.Fatalf("files keys = %v, want [%s]", keys, want)
+	}
+
+	l.Close()
+	if keys, _, _ := r.files(); len(keys) != 0 {
+		t.Fatalf("closed listener is still passed on: %v", keys)
+	}
+}
+
+func TestNotifyUpgradeReady(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	// notifyUpgradeReady closes the descriptor it writes to.
+	fd, err := unix.Dup(int(pw.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	t.Setenv(envUpgradeReadyFD, strconv.Itoa(fd))
+
+	if !notifyUpgradeReady() {
+		t.Fatal("notifyUpgradeReady = false, want true")
+	}
+	buf := make([]byte, 2)
+	n, err := pr.Read(buf)
+	if err != nil || n != 1 || buf[0] != 1 {
+		t.Fatalf("read %v (n=%d, err=%v), want a single ready byte", buf[:n], n, err)
+	}
+	if _, ok := os.LookupEnv(envUpgradeReadyFD); ok {
+		t.Errorf("%s was not unset", envUpgradeReadyFD)
+	}
+	if fdOpen(fd) {
+		t.Error("ready descriptor was not closed")
+	}
+}
+
+func TestNotifyUpgradeReadyNotUpgraded(t *testing.T) {
+	for _, value := range []string{"", "ready"} {
+		t.Setenv(envUpgradeReadyFD, value)
+		if notifyUpgradeReady() {
+			t.Fatalf("notifyUpgradeReady with %s=%q = true, want false", envUpgradeReadyFD, value)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -13,7 +14,10 @@ import (
 	"go-reauth-proxy/pkg/response"
 	"go-reauth-proxy/pkg/version"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -24,14 +28,18 @@ type Server struct {
 	ProxyHandler    *proxy.Handler
 	IptablesHandler *iptables.Handler
 	ConfigManager   *config.Manager
+	Host            string
 	Port            int
+
+	mu         sync.Mutex
+	httpServer *http.Server
 }
 
 type ServerInfo struct {
 	Version string `json:"version" example:"0.0.1"`
 }
 
-func NewServer(handler *proxy.Handler, port int, cfgManager *config.Manager, initialCfg *config.AppConfig) *Server {
+func NewServer(handler *proxy.Handler, host string, port int, cfgManager *config.Manager, initialCfg *config.AppConfig) *Server {
 	iptablesChainName := "REAUTH_FW"
 	if initialCfg != nil && initialCfg.IptablesChainName != "" {
 		iptablesChainName = initialCfg.IptablesChainName
@@ -47,22 +55,61 @@ func NewServer(handler *proxy.Handler, port int, cfgManager *config.Manager, ini
 		ProxyHandler:    handler,
 		IptablesHandler: iptablesHandler,
 		ConfigManager:   cfgManager,
+		Host:            host,
 		Port:            port,
 	}
 }
 
+// Addr returns the address the admin server listens on.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
 func (s *Server) Start() error {
+	l, err := net.Listen("tcp", s.Addr())
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve runs the admin API on an already open listener, which may have been
+// inherited from a previous process.
+func (s *Server) Serve(l net.Listener) error {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/api/rules", s.handleGetRules).Methods("GET")
 	r.HandleFunc("/api/rules", s.handleAddRule).Methods("POST")
 	r.HandleFunc("/api/rules", s.handleFlushRules).Methods("DELETE")
+	r.HandleFunc("/api/rules/match", s.handleRemoveRule).Methods("DELETE")
 	r.HandleFunc("/api/info", s.handleInfo).Methods("GET")
 	r.HandleFunc("/api/traffic", s.handleTraffic).Methods("GET")
+	r.HandleFunc("/api/traffic/transports", s.handleTransports).Methods("GET")
+	r.HandleFunc("/api/traffic/rate-limits", s.handleRateLimitStats).Methods("GET")
+	r.HandleFunc("/api/traffic/concurrency", s.handleConcurrencyStats).Methods("GET")
 	r.HandleFunc("/api/config/default-route", s.handleGetDefaultRoute).Methods("GET")
 	r.HandleFunc("/api/config/default-route", s.handleSetDefaultRoute).Methods("POST")
 	r.HandleFunc("/api/config/proxy-protocol", s.handleGetProxyProtocolForce).Methods("GET")
 	r.HandleFunc("/api/config/proxy-protocol", s.handleSetProxyProtocolForce).Methods("POST")
+	r.HandleFunc("/api/config/target-allowlist", s.handleGetTargetAllowlist).Methods("GET")
+	r.HandleFunc("/api/config/target-allowlist", s.handleSetTargetAllowlist).Methods("POST")
+	r.HandleFunc("/api/config/trusted-proxies", s.handleGetTrustedProxies).Methods("GET")
+	r.HandleFunc("/api/config/trusted-proxies", s.handleSetTrustedProxies).Methods("POST")
+	r.HandleFunc("/api/config/rate-limit", s.handleGetRateLimit).Methods("GET")
+	r.HandleFunc("/api/config/rate-limit", s.handleSetRateLimit).Methods("POST")
+	r.HandleFunc("/api/config/client-limits", s.handleGetClientLimits).Methods("GET")
+	r.HandleFunc("/api/config/client-limits", s.handleSetClientLimits).Methods("POST")
+	r.HandleFunc("/api/config/ip-filter", s.handleGetIPFilter).Methods("GET")
+	r.HandleFunc("/api/config/ip-filter", s.handleSetIPFilter).Methods("POST")
+	r.HandleFunc("/api/config/geoip", s.handleGetGeoIP).Methods("GET")
+	r.HandleFunc("/api/config/geoip", s.handleSetGeoIP).Methods("POST")
+	r.HandleFunc("/api/geoip/status", s.handleGeoIPStatus).Methods("GET")
+	r.HandleFunc("/api/geoip/lookup", s.handleGeoIPLookup).Methods("GET")
+	r.HandleFunc("/api/config/fallback", s.handleGetFallback).Methods("GET")
+	r.HandleFunc("/api/config/fallback", s.handleSetFallback).Methods("POST")
+	r.HandleFunc("/api/listeners", s.handleGetListeners).Methods("GET")
+	r.HandleFunc("/api/listeners", s.handleSetListeners).Methods("POST")
+	r.HandleFunc("/api/listeners", s.handleFlushListeners).Methods("DELETE")
 	r.HandleFunc("/api/auth", s.handleGetAuth).Methods("GET")
 	r.HandleFunc("/api/auth", s.handleSetAuth).Methods("POST")
 	r.HandleFunc("/api/ssl", s.handleGetSSL).Methods("GET")
@@ -89,8 +136,7 @@ func (s *Server) Start() error {
 	r.HandleFunc("/api/iptables/allow-all", s.IptablesHandler.HandleAllowAll).Methods("POST")
 	r.HandleFunc("/api/iptables/list", s.IptablesHandler.HandleList).Methods("GET")
 
-	addr := fmt.Sprintf("127.0.0.1:%d", s.Port)
-	fmt.Printf("Admin Server listening on %s\n", addr)
+	fmt.Printf("Admin Server listening on %s\n", l.Addr())
 
 	loggedRouter := middleware.Logger(middleware.CORS(r))
 
@@ -101,7 +147,27 @@ func (s *Server) Start() error {
 		response.Error(w, errors.CodeBadRequest, "Method Not Allowed")
 	})
 
-	return http.ListenAndServe(addr, loggedRouter)
+	s.mu.Lock()
+	s.httpServer = &http.Server{Handler: loggedRouter}
+	srv := s.httpServer
+	s.mu.Unlock()
+
+	err := srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting admin requests and waits for running ones.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.httpServer
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
 }
 
 // handleGetRules returns all proxy rules
@@ -116,9 +182,23 @@ func (s *Server) handleGetRules(w http.ResponseWriter, r *http.Request) {
 	response.Success(w, rules)
 }
 
+// ruleRequest defaults strip_path and rewrite_html to true when omitted.
+type ruleRequest struct {
+	models.Rule
+	StripPath   *bool `json:"strip_path"`
+	RewriteHTML *bool `json:"rewrite_html"`
+}
+
+func (req ruleRequest) toRule() models.Rule {
+	rule := req.Rule
+	rule.StripPath = req.StripPath == nil || *req.StripPath
+	rule.RewriteHTML = req.RewriteHTML == nil || *req.RewriteHTML
+	return rule
+}
+
 // handleAddRule sets proxy rules (overrides existing)
 // @Summary Set rules
-// @Description Set proxy rules (overrides existing rules)
+// @Description Set proxy rules (overrides existing rules). The rules are validated first, an invalid rule leaves the current rules unchanged.
 // @Tags rules
 // @Accept  json
 // @Produce  json
@@ -134,52 +214,26 @@ func (s *Server) handleAddRule(w http.ResponseWriter, r *http.Request) {
 	}
 	r.Body.Close()
 
-	type ruleRequest struct {
-		Path        string `json:"path"`
-		Target      string `json:"target"`
-		UseAuth     *bool  `json:"use_auth"`
-		StripPath   *bool  `json:"strip_path"`
-		RewriteHTML *bool  `json:"rewrite_html"`
-		UseRootMode *bool  `json:"use_root_mode"`
-	}
-
 	var reqs []ruleRequest
 	if err := json.Unmarshal(bodyBytes, &reqs); err != nil {
 		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON array: "+err.Error())
 		return
 	}
 
-	s.ProxyHandler.FlushRules()
-
 	var addedRules []models.Rule
 	for _, req := range reqs {
-		stripPath := true
-		if req.StripPath != nil {
-			stripPath = *req.StripPath
-		}
-
-		rewriteHTML := true
-		if req.RewriteHTML != nil {
-			rewriteHTML = *req.RewriteHTML
-		}
-
-		rule := models.Rule{
-			Path:        req.Path,
-			Target:      req.Target,
-			UseAuth:     req.UseAuth != nil && *req.UseAuth,
-			StripPath:   stripPath,
-			RewriteHTML: rewriteHTML,
-			UseRootMode: req.UseRootMode != nil && *req.UseRootMode,
-		}
-
-		if err := s.ProxyHandler.AddRule(rule); err != nil {
-			response.Error(w, errors.CodeInvalidRule, fmt.Sprintf("Failed to add rule: %v", err))
-			return
+		addedRules = append(addedRules, req.toRule())
+	}
+	if err := s.ProxyHandler.SetRules(addedRules); err != nil {
+		code := errors.CodeInvalidRule
+		if customErr, ok := err.(*errors.CustomError); ok {
+			code = customErr.Code
 		}
-		addedRules = append(addedRules, rule)
+		response.Error(w, code, fmt.Sprintf("Failed to add rule: %v", err))
+		return
 	}
 
-	response.Success(w, addedRules)
+	response.Success(w, s.ProxyHandler.GetRules())
 }
 
 // handleFlushRules clears all proxy rules
@@ -194,6 +248,36 @@ func (s *Server) handleFlushRules(w http.ResponseWriter, r *http.Request) {
 	response.Success(w, nil)
 }
 
+// handleRemoveRule removes a single rule
+// @Summary Remove a rule
+// @Description Remove the rule whose path, match_type, match_pattern, methods, match_headers, match_query and match_cookies equal the given ones. Other rules on the same path are kept. Method order and header name case are ignored.
+// @Tags rules
+// @Accept  json
+// @Produce  json
+// @Param rule body models.Rule true "Match fields of the rule to remove"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/rules/match [delete]
+func (s *Server) handleRemoveRule(w http.ResponseWriter, r *http.Request) {
+	var req models.Rule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	removed, err := s.ProxyHandler.RemoveRule(req)
+	if err != nil {
+		response.Error(w, errors.CodeInvalidRule, err.Error())
+		return
+	}
+	if !removed {
+		response.Error(w, errors.CodeRuleNotFound, "No rule matches the given path and predicates")
+		return
+	}
+	response.Success(w, nil)
+}
+
 // handleInfo returns server information
 // @Summary Get server info
 // @Description Get version and other server info
@@ -209,7 +293,7 @@ func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
 
 // handleTraffic returns proxy traffic stats
 // @Summary Get traffic stats
-// @Description Get proxy traffic stats (bytes in/out, active logged-in users in last 2 minutes, and 5xx count)
+// @Description Get proxy traffic stats (bytes in/out, active logged-in users in last 2 minutes, 5xx count, rate limited requests, queued requests, requests rejected by concurrency limits, requests rejected by size limits or read timeouts, connections rejected by max_conns_per_ip, requests rejected by IP filters and requests rejected by GeoIP filters)
 // @Tags traffic
 // @Produce  json
 // @Success 200 {object} response.Response{data=proxy.TrafficStats}
@@ -218,6 +302,39 @@ func (s *Server) handleTraffic(w http.ResponseWriter, r *http.Request) {
 	response.Success(w, s.ProxyHandler.GetTrafficStats(time.Now()))
 }
 
+// handleTransports returns upstream connection pool stats
+// @Summary Get upstream transport pool stats
+// @Description Get per-upstream transport stats (requests, dials, reused and open connections)
+// @Tags traffic
+// @Produce  json
+// @Success 200 {object} response.Response{data=[]proxy.TransportStats}
+// @Router /api/traffic/transports [get]
+func (s *Server) handleTransports(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetTransportStats())
+}
+
+// handleRateLimitStats returns rate limiter stats
+// @Summary Get rate limit stats
+// @Description Get the global and per-rule rate limiters with their settings, tracked clients and allowed / rejected request counts
+// @Tags traffic
+// @Produce  json
+// @Success 200 {object} response.Response{data=[]proxy.RateLimitStats}
+// @Router /api/traffic/rate-limits [get]
+func (s *Server) handleRateLimitStats(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetRateLimitStats())
+}
+
+// handleConcurrencyStats returns per-rule concurrency limiter stats
+// @Summary Get concurrency stats
+// @Description Get rules with max_in_flight: requests in flight, queue depth, queue wait times and requests rejected because the queue was full or timed out
+// @Tags traffic
+// @Produce  json
+// @Success 200 {object} response.Response{data=[]proxy.ConcurrencyStats}
+// @Router /api/traffic/concurrency [get]
+func (s *Server) handleConcurrencyStats(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetConcurrencyStats())
+}
+
 // handleGetDefaultRoute gets the default route
 // @Summary Get default route
 // @Description Get the configured default route when root route is requested
@@ -313,6 +430,360 @@ func (s *Server) handleSetProxyProtocolForce(w http.ResponseWriter, r *http.Requ
 	response.Success(w, proxyProtocolForceResponse{ProxyProtocolForce: *req.ProxyProtocolForce})
 }
 
+type targetAllowlistRequest struct {
+	TargetAllowlist []string `json:"target_allowlist" example:"api.example.com,*.example.org,203.0.113.0/24"`
+}
+
+// handleGetTargetAllowlist gets the external target allowlist
+// @Summary Get target allowlist
+// @Description Get the external hosts, IPs and CIDRs that proxy rules may target
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=targetAllowlistRequest}
+// @Router /api/config/target-allowlist [get]
+func (s *Server) handleGetTargetAllowlist(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, targetAllowlistRequest{TargetAllowlist: s.ProxyHandler.GetTargetAllowlist()})
+}
+
+// handleSetTargetAllowlist sets the external target allowlist
+// @Summary Set target allowlist
+// @Description Replace the external hosts ("api.example.com", "*.example.com"), IPs and CIDRs that proxy rules may target. Other targets must resolve to internal addresses; this is checked on every connection.
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body targetAllowlistRequest true "Allowlist entries"
+// @Success 200 {object} response.Response{data=targetAllowlistRequest}
+// @Failure 400 {object} response.Response
+// @Router /api/config/target-allowlist [post]
+func (s *Server) handleSetTargetAllowlist(w http.ResponseWriter, r *http.Request) {
+	var req targetAllowlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+	if req.TargetAllowlist == nil {
+		req.TargetAllowlist = []string{}
+	}
+
+	if err := s.ProxyHandler.SetTargetAllowlist(req.TargetAllowlist); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid target_allowlist: "+err.Error())
+		return
+	}
+	response.Success(w, req)
+}
+
+// handleGetTrustedProxies gets the trusted proxy networks
+// @Summary Get trusted proxies
+// @Description Get the networks allowed to report client addresses through PROXY protocol or forwarded headers
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.TrustedProxyConfig}
+// @Router /api/config/trusted-proxies [get]
+func (s *Server) handleGetTrustedProxies(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetTrustedProxies())
+}
+
+// handleSetTrustedProxies sets the trusted proxy networks
+// @Summary Set trusted proxies
+// @Description Set trusted proxy networks with their PROXY protocol policy ("use", "require", "ignore", "reject") and whether X-Forwarded-For / X-Real-IP are honored. Other sources never set the client address.
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.TrustedProxyConfig true "Trusted proxy networks"
+// @Success 200 {object} response.Response{data=models.TrustedProxyConfig}
+// @Failure 400 {object} response.Response
+// @Router /api/config/trusted-proxies [post]
+func (s *Server) handleSetTrustedProxies(w http.ResponseWriter, r *http.Request) {
+	var req models.TrustedProxyConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	if err := s.ProxyHandler.SetTrustedProxies(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid trusted proxies: "+err.Error())
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetTrustedProxies())
+}
+
+// handleGetRateLimit gets the global rate limit
+// @Summary Get global rate limit
+// @Description Get the rate limit applied to every proxied request in addition to per-rule limits
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.RateLimit}
+// @Router /api/config/rate-limit [get]
+func (s *Server) handleGetRateLimit(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetRateLimit())
+}
+
+// handleSetRateLimit sets the global rate limit
+// @Summary Set global rate limit
+// @Description Set the token bucket applied to every proxied request: rate (requests per second, 0 disables), burst and key ("ip", "identity" or "header:<name>")
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.RateLimit true "Global rate limit"
+// @Success 200 {object} response.Response{data=models.RateLimit}
+// @Failure 400 {object} response.Response
+// @Router /api/config/rate-limit [post]
+func (s *Server) handleSetRateLimit(w http.ResponseWriter, r *http.Request) {
+	var req models.RateLimit
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	if err := s.ProxyHandler.SetRateLimit(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid rate limit: "+err.Error())
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetRateLimit())
+}
+
+// handleGetClientLimits gets the global client limits
+// @Summary Get client limits
+// @Description Get the default request body size, header size and body read timeout limits, and the per-IP connection limit of the proxy ports
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.ClientLimits}
+// @Router /api/config/client-limits [get]
+func (s *Server) handleGetClientLimits(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetClientLimits())
+}
+
+// handleSetClientLimits sets the global client limits
+// @Summary Set client limits
+// @Description Set max_body_size (bytes), max_header_bytes, read_timeout (seconds) and max_conns_per_ip, 0 disables a limit. Rules can override the first three.
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.ClientLimits true "Client limits"
+// @Success 200 {object} response.Response{data=models.ClientLimits}
+// @Failure 400 {object} response.Response
+// @Router /api/config/client-limits [post]
+func (s *Server) handleSetClientLimits(w http.ResponseWriter, r *http.Request) {
+	var req models.ClientLimits
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	if err := s.ProxyHandler.SetClientLimits(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid client limits: "+err.Error())
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetClientLimits())
+}
+
+// handleGetIPFilter gets the global IP filter
+// @Summary Get global IP filter
+// @Description Get the client IP allow/deny lists applied to every request on the proxy ports before rule filters
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.IPFilter}
+// @Router /api/config/ip-filter [get]
+func (s *Server) handleGetIPFilter(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetIPFilter())
+}
+
+// handleSetIPFilter sets the global IP filter
+// @Summary Set global IP filter
+// @Description Set the allow and deny lists (IPs or CIDRs, deny wins, a non-empty allow list admits only matching clients) and the action for denied clients: "forbid" (403 page) or "drop" (close the connection)
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.IPFilter true "Global IP filter"
+// @Success 200 {object} response.Response{data=models.IPFilter}
+// @Failure 400 {object} response.Response
+// @Router /api/config/ip-filter [post]
+func (s *Server) handleSetIPFilter(w http.ResponseWriter, r *http.Request) {
+	var req models.IPFilter
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	if err := s.ProxyHandler.SetIPFilter(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid IP filter: "+err.Error())
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetIPFilter())
+}
+
+// handleGetGeoIP gets the GeoIP configuration
+// @Summary Get GeoIP configuration
+// @Description Get the MMDB database paths, the reload interval and the countries blocked via iptables
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.GeoIPConfig}
+// @Router /api/config/geoip [get]
+func (s *Server) handleGetGeoIP(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetGeoIP())
+}
+
+// handleSetGeoIP sets the GeoIP configuration
+// @Summary Set GeoIP configuration
+// @Description Set absolute paths of the country and ASN MMDB databases (may be the same file, empty disables), how often they are checked for changes and the countries whose networks are dropped in the iptables chain
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.GeoIPConfig true "GeoIP configuration"
+// @Success 200 {object} response.Response{data=models.GeoIPConfig}
+// @Failure 400 {object} response.Response
+// @Router /api/config/geoip [post]
+func (s *Server) handleSetGeoIP(w http.ResponseWriter, r *http.Request) {
+	var req models.GeoIPConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+
+	if err := s.ProxyHandler.SetGeoIP(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid GeoIP config: "+err.Error())
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetGeoIP())
+}
+
+// handleGeoIPStatus returns the loaded GeoIP databases
+// @Summary Get GeoIP database status
+// @Description Get the type, build time and load time of each configured database, and the last load error
+// @Tags geoip
+// @Produce  json
+// @Success 200 {object} response.Response{data=[]geoip.DatabaseStatus}
+// @Router /api/geoip/status [get]
+func (s *Server) handleGeoIPStatus(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetGeoIPStatus())
+}
+
+// handleGeoIPLookup looks up an address in the GeoIP databases
+// @Summary Look up an IP address
+// @Description Get the country and ASN the loaded databases report for an IP address
+// @Tags geoip
+// @Produce  json
+// @Param ip query string true "IP address"
+// @Success 200 {object} response.Response{data=geoip.Record}
+// @Failure 400 {object} response.Response
+// @Router /api/geoip/lookup [get]
+func (s *Server) handleGeoIPLookup(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.ProxyHandler.LookupGeoIP(r.URL.Query().Get("ip"))
+	if err != nil {
+		response.Error(w, errors.CodeBadRequest, err.Error())
+		return
+	}
+	response.Success(w, rec)
+}
+
+// handleGetFallback gets the fallback routing configuration
+// @Summary Get fallback routing
+// @Description Get how requests that match no rule path are routed (cookie / Referer strategies, strict mode, debug header)
+// @Tags config
+// @Produce  json
+// @Success 200 {object} response.Response{data=models.FallbackConfig}
+// @Router /api/config/fallback [get]
+func (s *Server) handleGetFallback(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetFallbackConfig())
+}
+
+// handleSetFallback sets the fallback routing configuration
+// @Summary Set fallback routing
+// @Description Set the ordered fallback strategies ("cookie", "referer", empty list disables), strict mode and the X-Proxy-Route debug header
+// @Tags config
+// @Accept  json
+// @Produce  json
+// @Param request body models.FallbackConfig true "Fallback routing options"
+// @Success 200 {object} response.Response{data=models.FallbackConfig}
+// @Failure 400 {object} response.Response
+// @Router /api/config/fallback [post]
+func (s *Server) handleSetFallback(w http.ResponseWriter, r *http.Request) {
+	var req models.FallbackConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON object")
+		return
+	}
+	if req.Strategies == nil {
+		response.Error(w, errors.CodeBadRequest, "strategies is required")
+		return
+	}
+
+	if err := s.ProxyHandler.SetFallbackConfig(req); err != nil {
+		response.Error(w, errors.CodeBadRequest, "Invalid fallback config: "+err.Error())
+		return
+	}
+	response.Success(w, req)
+}
+
+type listenerRequest struct {
+	models.PortConfig
+	Rules []ruleRequest `json:"rules"`
+}
+
+// handleGetListeners returns the additional proxy listeners
+// @Summary Get listeners
+// @Description Get the additional proxy listeners and their rules. Private keys are not returned.
+// @Tags listeners
+// @Produce  json
+// @Success 200 {object} response.Response{data=[]models.PortConfig}
+// @Router /api/listeners [get]
+func (s *Server) handleGetListeners(w http.ResponseWriter, r *http.Request) {
+	response.Success(w, s.ProxyHandler.GetListeners())
+}
+
+// handleSetListeners sets the additional proxy listeners (overrides existing)
+// @Summary Set listeners
+// @Description Set additional proxy listeners, each with its own rules, default route, PROXY protocol policy and optional certificate. Listeners are started, stopped and rebound immediately.
+// @Tags listeners
+// @Accept  json
+// @Produce  json
+// @Param listeners body []models.PortConfig true "List of listeners to set"
+// @Success 200 {object} response.Response{data=[]models.PortConfig}
+// @Failure 400 {object} response.Response
+// @Router /api/listeners [post]
+func (s *Server) handleSetListeners(w http.ResponseWriter, r *http.Request) {
+	var reqs []listenerRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		response.Error(w, errors.CodeInvalidJSON, "Invalid JSON array: "+err.Error())
+		return
+	}
+
+	listeners := make([]models.PortConfig, 0, len(reqs))
+	for _, req := range reqs {
+		listener := req.PortConfig
+		listener.Rules = make([]models.Rule, 0, len(req.Rules))
+		for _, rule := range req.Rules {
+			listener.Rules = append(listener.Rules, rule.toRule())
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if err := s.ProxyHandler.SetListeners(listeners); err != nil {
+		code := errors.CodeInvalidRule
+		if customErr, ok := err.(*errors.CustomError); ok {
+			code = customErr.Code
+		}
+		response.Error(w, code, fmt.Sprintf("Failed to set listeners: %v", err))
+		return
+	}
+	response.Success(w, s.ProxyHandler.GetListeners())
+}
+
+// handleFlushListeners removes all additional proxy listeners
+// @Summary Flush listeners
+// @Description Stop and remove all additional proxy listeners
+// @Tags listeners
+// @Produce  json
+// @Success 200 {object} response.Response
+// @Router /api/listeners [delete]
+func (s *Server) handleFlushListeners(w http.ResponseWriter, r *http.Request) {
+	if err := s.ProxyHandler.SetListeners(nil); err != nil {
+		response.Error(w, errors.CodeInternal, err.Error())
+		return
+	}
+	response.Success(w, nil)
+}
+
 // handleGetAuth gets the global auth configuration (port and relative urls)
 // @Summary Get global auth config
 // @Description Get the configured global authentication URLs and port
@@ -9,14 +9,25 @@ import (
 )
 
 type AppConfig struct {
-	Rules              []models.Rule     `json:"rules"`
-	DefaultRoute       string            `json:"default_route"`
-	AuthConfig         models.AuthConfig `json:"auth_config"`
-	AdminPort          int               `json:"admin_port,omitempty"`
-	ProxyProtocolForce bool              `json:"proxy_protocol_force,omitempty"`
-	IptablesChainName  string            `json:"iptables_chain_name,omitempty"`
-	SSLCert            string            `json:"ssl_cert,omitempty"`
-	SSLKey             string            `json:"ssl_key,omitempty"`
+	Rules              []models.Rule             `json:"rules"`
+	DefaultRoute       string                    `json:"default_route"`
+	AuthConfig         models.AuthConfig         `json:"auth_config"`
+	AdminPort          int                       `json:"admin_port,omitempty"`
+	AdminBind          string                    `json:"admin_bind,omitempty"`
+	ProxyBind          []string                  `json:"proxy_bind,omitempty"`
+	ProxyProtocolForce bool                      `json:"proxy_protocol_force,omitempty"`
+	TargetAllowlist    []string                  `json:"target_allowlist,omitempty"`
+	Fallback           models.FallbackConfig     `json:"fallback"`
+	Listeners          []models.PortConfig       `json:"listeners,omitempty"`
+	TrustedProxies     models.TrustedProxyConfig `json:"trusted_proxies"`
+	RateLimit          models.RateLimit          `json:"rate_limit"`
+	ClientLimits       models.ClientLimits       `json:"client_limits"`
+	IPFilter           models.IPFilter           `json:"ip_filter"`
+	GeoIP              models.GeoIPConfig        `json:"geoip"`
+	DrainTimeout       int                       `json:"drain_timeout,omitempty"`
+	IptablesChainName  string                    `json:"iptables_chain_name,omitempty"`
+	SSLCert            string                    `json:"ssl_cert,omitempty"`
+	SSLKey             string                    `json:"ssl_key,omitempty"`
 }
 
 type Manager struct {
@@ -43,6 +54,13 @@ func defaultConfig() *AppConfig {
 		},
 		AdminPort:          7996,
 		ProxyProtocolForce: false,
+		Fallback: models.FallbackConfig{
+			Strategies: []string{"cookie", "referer"},
+		},
+		TrustedProxies: models.TrustedProxyConfig{
+			Proxies:       []models.TrustedProxy{},
+			ProxyProtocol: "ignore",
+		},
 	}
 }
 
@@ -73,6 +91,15 @@ func applyDefaults(cfg *AppConfig) {
 	if cfg.AdminPort <= 0 {
 		cfg.AdminPort = 7996
 	}
+	if cfg.Fallback.Strategies == nil {
+		cfg.Fallback.Strategies = []string{"cookie", "referer"}
+	}
+	if cfg.TrustedProxies.Proxies == nil {
+		cfg.TrustedProxies.Proxies = []models.TrustedProxy{}
+	}
+	if cfg.TrustedProxies.ProxyProtocol == "" {
+		cfg.TrustedProxies.ProxyProtocol = "ignore"
+	}
 }
 
 func (m *Manager) loadUnlocked() (*AppConfig, bool, error) {
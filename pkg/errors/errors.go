@@ -17,9 +17,16 @@ const (
 	CodeReadBodyFailed = 10004
 
 	// Proxy Errors
-	CodeProxyTargetInvalid = 20001
-	CodeProxyAuthFailed    = 20002
-	CodeProxyTimeout       = 20003
+	CodeProxyTargetInvalid   = 20001
+	CodeProxyAuthFailed      = 20002
+	CodeProxyTimeout         = 20003
+	CodeProxyTargetForbidden = 20004
+	CodeProxyRateLimited     = 20005
+	CodeProxyOverloaded      = 20006
+	CodeRequestTooLarge      = 20007
+	CodeHeadersTooLarge      = 20008
+	CodeRequestTimeout       = 20009
+	CodeProxyClientDenied    = 20010
 
 	// Iptables Errors
 	CodeIptablesInitError    = 30001
@@ -57,6 +64,13 @@ var ErrorMap = map[int]string{
 	CodeProxyTargetInvalid:   "Invalid Proxy Target",
 	CodeProxyAuthFailed:      "Authentication Failed",
 	CodeProxyTimeout:         "Upstream Timeout",
+	CodeProxyTargetForbidden: "Proxy Target Not Allowed",
+	CodeProxyRateLimited:     "Too Many Requests",
+	CodeProxyOverloaded:      "Service Overloaded",
+	CodeRequestTooLarge:      "Request Entity Too Large",
+	CodeHeadersTooLarge:      "Request Header Fields Too Large",
+	CodeRequestTimeout:       "Request Timeout",
+	CodeProxyClientDenied:    "Client Address Not Allowed",
 	CodeIptablesInitError:    "Iptables Initialization Failed",
 	CodeIptablesCommandError: "Iptables Command Failed",
 	CodeIptablesParseError:   "Iptables Parse Failed",
@@ -0,0 +1,343 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Record is the GeoIP information of a client address. Fields are empty
+// when no database knows the address.
+type Record struct {
+	Country string `json:"country,omitempty" example:"DE"`              // ISO 3166-1 alpha-2 country code
+	ASN     uint   `json:"asn,omitempty" example:"3320"`                // Autonomous system number
+	ASOrg   string `json:"as_org,omitempty" example:"Deutsche Telekom"` // Autonomous system organization
+}
+
+// DatabaseStatus describes a loaded database file.
+type DatabaseStatus struct {
+	Path         string    `json:"path"`
+	Loaded       bool      `json:"loaded"`
+	DatabaseType string    `json:"database_type,omitempty" example:"GeoLite2-Country"`
+	BuildTime    time.Time `json:"build_time,omitempty"`
+	LoadedAt     time.Time `json:"loaded_at,omitempty"`
+	Error        string    `json:"error,omitempty"` // Last load error, the previous version stays in use
+}
+
+// mmdbRecord holds the fields read from country and ASN databases.
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+func (m mmdbRecord) record() Record {
+	country := m.Country.ISOCode
+	if country == "" {
+		country = m.RegisteredCountry.ISOCode
+	}
+	return Record{Country: strings.ToUpper(country), ASN: m.ASN, ASOrg: m.ASOrg}
+}
+
+type database struct {
+	path     string
+	reader   *maxminddb.Reader
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	err      error
+	cache    *sync.Map // data offset -> Record
+}
+
+// DB resolves client addresses with a country and an ASN database, which
+// may be the same file. Files are checked for changes periodically and
+// reloaded in place.
+type DB struct {
+	mu       sync.RWMutex
+	dbs      []*database
+	onReload func()
+	stop     chan struct{}
+}
+
+func New() *DB {
+	return &DB{}
+}
+
+// Check reports whether path can be opened as a MaxMind DB file.
+func Check(path string) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	return reader.Close()
+}
+
+// Configure loads the given files, skipping empty paths, and starts watching
+// them. It returns the first load error, the other files stay loaded.
+func (db *DB) Configure(paths []string, interval time.Duration, onReload func()) error {
+	var firstErr error
+	var dbs []*database
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		d := &database{path: path}
+		if err := d.load(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		dbs = append(dbs, d)
+	}
+
+	db.mu.Lock()
+	if db.stop != nil {
+		close(db.stop)
+		db.stop = nil
+	}
+	db.dbs = dbs
+	db.onReload = onReload
+	if len(dbs) > 0 && interval > 0 {
+		db.stop = make(chan struct{})
+		go db.watch(db.stop, interval)
+	}
+	db.mu.Unlock()
+	return firstErr
+}
+
+// load reads the file. The size and modification time are recorded even when
+// it fails, so a broken file is only retried once it changes. The file is
+// read into memory rather than mapped, as it may be rewritten in place.
+func (d *database) load() error {
+	info, err := os.Stat(d.path)
+	if err == nil {
+		d.modTime, d.size = info.ModTime(), info.Size()
+		var buf []byte
+		var reader *maxminddb.Reader
+		buf, err = os.ReadFile(d.path)
+		if err == nil {
+			reader, err = maxminddb.OpenBytes(buf)
+		}
+		if err == nil {
+			d.reader = reader
+			d.loadedAt = time.Now()
+			d.cache = &sync.Map{}
+		}
+	}
+	d.err = err
+	if err != nil {
+		return fmt.Errorf("%s: %v", d.path, err)
+	}
+	return nil
+}
+
+func (db *DB) watch(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if db.reloadChanged(stop) {
+			db.mu.RLock()
+			onReload := db.onReload
+			db.mu.RUnlock()
+			if onReload != nil {
+				onReload()
+			}
+		}
+	}
+}
+
+// reloadChanged reloads files whose size or modification time changed. A
+// file that fails to load, for example while it is being replaced, keeps
+// the previous version and is retried once it changes again. Files are read
+// without holding the lock, so lookups continue meanwhile.
+func (db *DB) reloadChanged(stop chan struct{}) bool {
+	db.mu.RLock()
+	current := append([]*database(nil), db.dbs...)
+	db.mu.RUnlock()
+
+	reloaded := make(map[*database]*database)
+	for _, d := range current {
+		info, err := os.Stat(d.path)
+		if err != nil || (info.ModTime().Equal(d.modTime) && info.Size() == d.size) {
+			continue
+		}
+		next := &database{path: d.path}
+		if err := next.load(); err != nil {
+			log.Printf("Failed to reload GeoIP database %v", err)
+			db.mu.Lock()
+			d.err = err
+			d.modTime, d.size = next.modTime, next.size
+			db.mu.Unlock()
+			continue
+		}
+		reloaded[d] = next
+		log.Printf("Reloaded GeoIP database %s", d.path)
+	}
+	if len(reloaded) == 0 {
+		return false
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	select {
+	case <-stop:
+		// Configure replaced the databases in the meantime.
+		return false
+	default:
+	}
+	for i, d := range db.dbs {
+		if next, ok := reloaded[d]; ok {
+			db.dbs[i] = next
+		}
+	}
+	return true
+}
+
+// Loaded reports whether at least one database is available.
+func (db *DB) Loaded() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, d := range db.dbs {
+		if d.reader != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *database) lookup(ip net.IP) Record {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Record{}
+	}
+	result := d.reader.Lookup(addr.Unmap())
+	if !result.Found() {
+		return Record{}
+	}
+	if cached, ok := d.cache.Load(result.Offset()); ok {
+		return cached.(Record)
+	}
+	var fields mmdbRecord
+	if err := result.Decode(&fields); err != nil {
+		return Record{}
+	}
+	rec := fields.record()
+	d.cache.Store(result.Offset(), rec)
+	return rec
+}
+
+// Lookup merges the records of all databases, the first one providing a
+// field wins.
+func (db *DB) Lookup(ip net.IP) Record {
+	var rec Record
+	if ip == nil {
+		return rec
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, d := range db.dbs {
+		if d.reader == nil {
+			continue
+		}
+		found := d.lookup(ip)
+		if rec.Country == "" {
+			rec.Country = found.Country
+		}
+		if rec.ASN == 0 {
+			rec.ASN, rec.ASOrg = found.ASN, found.ASOrg
+		}
+	}
+	return rec
+}
+
+// CountryNetworks returns the networks located in the given countries, as
+// CIDRs sorted with IPv4 first.
+func (db *DB) CountryNetworks(countries []string) ([]string, error) {
+	want := make(map[string]bool, len(countries))
+	for _, c := range countries {
+		want[strings.ToUpper(c)] = true
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, d := range db.dbs {
+		if d.reader == nil {
+			continue
+		}
+		var networks []netip.Prefix
+		hasCountries := false
+		matches := make(map[uintptr]bool)
+		// Aliases of the IPv4 space in IPv6 databases are skipped.
+		for result := range d.reader.Networks() {
+			if err := result.Err(); err != nil {
+				return nil, fmt.Errorf("%s: %v", d.path, err)
+			}
+			match, ok := matches[result.Offset()]
+			if !ok {
+				var fields mmdbRecord
+				if err := result.Decode(&fields); err != nil {
+					return nil, fmt.Errorf("%s: %v", d.path, err)
+				}
+				country := fields.record().Country
+				hasCountries = hasCountries || country != ""
+				match = want[country]
+				matches[result.Offset()] = match
+			}
+			if match {
+				networks = append(networks, result.Prefix())
+			}
+		}
+		if !hasCountries {
+			continue
+		}
+		sort.SliceStable(networks, func(i, j int) bool {
+			return networks[i].Addr().Is4() && !networks[j].Addr().Is4()
+		})
+		out := make([]string, len(networks))
+		for i, network := range networks {
+			out[i] = network.String()
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("no country database is loaded")
+}
+
+func (db *DB) Status() []DatabaseStatus {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	out := make([]DatabaseStatus, 0, len(db.dbs))
+	for _, d := range db.dbs {
+		s := DatabaseStatus{Path: d.path, Loaded: d.reader != nil}
+		if d.reader != nil {
+			s.DatabaseType = d.reader.Metadata.DatabaseType
+			s.BuildTime = d.reader.Metadata.BuildTime().UTC()
+			s.LoadedAt = d.loadedAt
+		}
+		if d.err != nil {
+			s.Error = d.err.Error()
+		}
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,298 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDBLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		fx, _ := fixture(recordSize)
+		db, err := openDB(t, fx.build(t))
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		if status := db.Status(); status[0].DatabaseType != "Test-Country" || status[0].BuildTime.Unix() != 1700000000 {
+			t.Errorf("record size %d: Status = %+v", recordSize, status)
+		}
+		for ip, want := range map[string]string{
+			"127.0.0.1":        "DE",
+			"127.255.255.255":  "DE",
+			"::ffff:127.0.0.1": "DE",
+			"10.1.2.3":         "US",
+			"2001:db8::1":      "FR",
+			"2002:7f00:1::":    "DE", // 6to4 alias of 127.0.0.1
+			"8.8.8.8":          "",
+			"2001:db9::1":      "",
+		} {
+			if got := db.Lookup(net.ParseIP(ip)).Country; got != want {
+				t.Errorf("record size %d: Lookup(%s) = %q, want %q", recordSize, ip, got, want)
+			}
+		}
+	}
+}
+
+func TestDBIPv4Database(t *testing.T) {
+	fx := testDB{ipVersion: 4, recordSize: 24, data: encode(country("NL")), networks: []testNetwork{{"192.0.2.0/24", 0}}}
+	db, err := openDB(t, fx.build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup(net.ParseIP("192.0.2.10")).Country; got != "NL" {
+		t.Errorf("Lookup(192.0.2.10) = %q, want NL", got)
+	}
+	if got := db.Lookup(net.ParseIP("2001:db8::1")).Country; got != "" {
+		t.Errorf("IPv6 lookup in an IPv4 database = %q, want none", got)
+	}
+	if got, err := db.CountryNetworks([]string{"NL"}); err != nil || !slices.Equal(got, []string{"192.0.2.0/24"}) {
+		t.Errorf("CountryNetworks = %v, %v", got, err)
+	}
+}
+
+func TestDBRegisteredCountryAndPointers(t *testing.T) {
+	// The second record points at the country map of the first one, the
+	// third only has a registered country.
+	first := encode(country("SE"))
+	shared := uint(bytes.Index(first, encode(map[string]any{"iso_code": "SE"})))
+	data := append(first, control(typeMap, 1)...)
+	data = append(data, encode("country")...)
+	data = append(data, pointerTo(shared)...)
+	third := uint(len(data))
+	data = append(data, encode(map[string]any{"registered_country": map[string]any{"iso_code": "no"}})...)
+
+	fx := testDB{ipVersion: 6, recordSize: 24, data: data, networks: []testNetwork{
+		{"192.0.2.0/24", 0},
+		{"198.51.100.0/24", uint(len(first))},
+		{"203.0.113.0/24", third},
+	}}
+	db, err := openDB(t, fx.build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup(net.ParseIP("198.51.100.1")).Country; got != "SE" {
+		t.Errorf("Lookup through a pointer = %q, want SE", got)
+	}
+	if got := db.Lookup(net.ParseIP("203.0.113.1")).Country; got != "NO" {
+		t.Errorf("Lookup of a registered country = %q, want NO", got)
+	}
+}
+
+func TestDBBadPointer(t *testing.T) {
+	data := append(control(typeMap, 1), encode("country")...)
+	data = append(data, pointerTo(1500)...)
+	fx := testDB{ipVersion: 6, recordSize: 24, data: data, networks: []testNetwork{{"192.0.2.0/24", 0}}}
+	db, err := openDB(t, fx.build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup(net.ParseIP("192.0.2.1")); got != (Record{}) {
+		t.Fatalf("Lookup with a pointer past the data section = %+v", got)
+	}
+	if _, err := db.CountryNetworks([]string{"DE"}); err == nil {
+		t.Fatal("CountryNetworks with a pointer past the data section succeeded")
+	}
+}
+
+func TestDBTruncated(t *testing.T) {
+	fx, _ := fixture(24)
+	buf := fx.build(t)
+	metaStart := bytes.LastIndex(buf, metadataMarker)
+	hostile := append([]byte(nil), buf[:metaStart]...)
+	hostile = append(hostile, metadataMarker...)
+	hostile = append(hostile, encode(map[string]any{
+		"node_count":  1 << 62,
+		"record_size": 32,
+		"ip_version":  6,
+	})...)
+
+	for name, truncated := range map[string][]byte{
+		"empty":              nil,
+		"no metadata":        buf[:metaStart],
+		"partial metadata":   buf[:metaStart+len(metadataMarker)+5],
+		"missing tree":       buf[100:],
+		"hostile node count": hostile,
+	} {
+		db, err := openDB(t, truncated)
+		if err == nil {
+			t.Errorf("%s: Configure succeeded", name)
+		}
+		if db.Loaded() {
+			t.Errorf("%s: Loaded = true", name)
+		}
+	}
+
+	// A record cut off in the middle is not found.
+	cut := append([]byte(nil), buf[:metaStart-len(fx.data)+3]...)
+	db, err := openDB(t, append(cut, buf[metaStart:]...))
+	if err == nil {
+		if got := db.Lookup(net.ParseIP("10.0.0.1")); got != (Record{}) {
+			t.Errorf("truncated data section: Lookup = %+v", got)
+		}
+	}
+}
+
+func TestDBLookupMergesDatabases(t *testing.T) {
+	dir := t.TempDir()
+	countryDB, _ := fixture(24)
+	asnData := encode(map[string]any{"autonomous_system_number": 3320, "autonomous_system_organization": "Deutsche Telekom"})
+	asnDB := testDB{ipVersion: 6, recordSize: 24, data: asnData, networks: []testNetwork{{"127.0.0.0/16", 0}}}
+	countryPath, asnPath := filepath.Join(dir, "country.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeDB(t, countryPath, countryDB.build(t), time.Now())
+	writeDB(t, asnPath, asnDB.build(t), time.Now())
+
+	db := New()
+	if err := db.Configure([]string{countryPath, asnPath, ""}, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Loaded() {
+		t.Fatal("Loaded = false")
+	}
+	want := Record{Country: "DE", ASN: 3320, ASOrg: "Deutsche Telekom"}
+	if got := db.Lookup(net.ParseIP("127.0.0.1")); got != want {
+		t.Errorf("Lookup(127.0.0.1) = %+v, want %+v", got, want)
+	}
+	if got := db.Lookup(net.ParseIP("127.1.0.1")); got != (Record{Country: "DE"}) {
+		t.Errorf("Lookup(127.1.0.1) = %+v, want the country only", got)
+	}
+	if got := db.Lookup(nil); got != (Record{}) {
+		t.Errorf("Lookup(nil) = %+v", got)
+	}
+
+	status := db.Status()
+	if len(status) != 2 || !status[0].Loaded || status[0].DatabaseType != "Test-Country" || status[1].Path != asnPath {
+		t.Errorf("Status = %+v", status)
+	}
+}
+
+func TestDBConfigureMissingFile(t *testing.T) {
+	db := New()
+	if err := db.Configure([]string{filepath.Join(t.TempDir(), "missing.mmdb")}, 0, nil); err == nil {
+		t.Fatal("Configure with a missing file succeeded")
+	}
+	if db.Loaded() {
+		t.Fatal("Loaded = true without a readable database")
+	}
+	if _, err := db.CountryNetworks([]string{"DE"}); err == nil {
+		t.Fatal("CountryNetworks without a database succeeded")
+	}
+}
+
+func TestDBCountryNetworks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	fx, _ := fixture(28)
+	fx.data = append(fx.data, encode(country("US"))...)
+	fx.networks = append(fx.networks, testNetwork{"2001:db9::/32", fx.networks[1].offset}, testNetwork{"192.168.0.0/17", fx.networks[1].offset})
+	writeDB(t, path, fx.build(t), time.Now())
+
+	db := New()
+	if err := db.Configure([]string{path}, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.CountryNetworks([]string{"us", "FR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IPv4 first, aliases of the IPv4 subtree not repeated.
+	want := []string{"10.0.0.0/8", "192.168.0.0/17", "2001:db8::/32", "2001:db9::/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("CountryNetworks = %v, want %v", got, want)
+	}
+	if got, _ := db.CountryNetworks(nil); len(got) != 0 {
+		t.Errorf("CountryNetworks(nil) = %v", got)
+	}
+}
+
+func TestDBReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	first, _ := fixture(24)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeDB(t, path, first.build(t), modTime)
+
+	db := New()
+	if err := db.Configure([]string{path}, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	lookup := func() string { return db.Lookup(net.ParseIP("127.0.0.1")).Country }
+
+	if db.reloadChanged(stop) {
+		t.Fatal("unchanged file was reloaded")
+	}
+
+	second := first
+	second.data = encode(country("AT"))
+	second.networks = []testNetwork{{"127.0.0.0/8", 0}}
+	valid := second.build(t)
+	modTime = modTime.Add(time.Minute)
+	writeDB(t, path, valid, modTime)
+	if !db.reloadChanged(stop) || lookup() != "AT" {
+		t.Fatalf("changed file was not reloaded, Lookup = %q", lookup())
+	}
+
+	// A broken file keeps the previous version and reports the error.
+	broken := bytes.Repeat([]byte{'x'}, len(valid))
+	modTime = modTime.Add(time.Minute)
+	writeDB(t, path, broken, modTime)
+	if db.reloadChanged(stop) || lookup() != "AT" {
+		t.Fatalf("broken file replaced the database, Lookup = %q", lookup())
+	}
+	if status := db.Status(); status[0].Error == "" || !status[0].Loaded {
+		t.Fatalf("Status after a failed reload = %+v", status)
+	}
+
+	// It is not read again until it changes: a valid file with the same size
+	// and modification time goes unnoticed.
+	fourth := second
+	fourth.data = encode(country("CH"))
+	writeDB(t, path, fourth.build(t), modTime)
+	if db.reloadChanged(stop) || lookup() != "AT" {
+		t.Fatalf("unchanged broken file was read again, Lookup = %q", lookup())
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeDB(t, path, fourth.build(t), modTime)
+	if !db.reloadChanged(stop) || lookup() != "CH" {
+		t.Fatalf("fixed file was not reloaded, Lookup = %q", lookup())
+	}
+	if status := db.Status(); status[0].Error != "" {
+		t.Fatalf("error kept after a successful reload: %+v", status)
+	}
+
+	// Configure replacing the databases wins over a reload in progress.
+	close(stop)
+	modTime = modTime.Add(time.Minute)
+	writeDB(t, path, valid, modTime)
+	if db.reloadChanged(stop) || lookup() != "CH" {
+		t.Fatalf("reload applied after stop, Lookup = %q", lookup())
+	}
+}
+
+func TestDBWatchCallsOnReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	fx, _ := fixture(24)
+	writeDB(t, path, fx.build(t), time.Now().Add(-time.Hour))
+
+	reloaded := make(chan struct{}, 1)
+	db := New()
+	if err := db.Configure([]string{path}, 10*time.Millisecond, func() { reloaded <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Configure(nil, 0, nil)
+
+	fx.data = encode(country("AT"))
+	fx.networks = []testNetwork{{"127.0.0.0/8", 0}}
+	fx.ipv4Aliases = false
+	writeDB(t, path, fx.build(t), time.Now())
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("onReload was not called")
+	}
+	if got := db.Lookup(net.ParseIP("127.0.0.1")).Country; got != "AT" {
+		t.Fatalf("Lookup after watch reload = %q, want AT", got)
+	}
+}
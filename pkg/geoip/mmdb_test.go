@@ -0,0 +1,241 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Data types and markers of the MaxMind DB format, for building test files.
+const (
+	typePointer = 1
+	typeString  = 2
+	typeUint32  = 6
+	typeMap     = 7
+	typeUint64  = 9
+	typeArray   = 11
+
+	dataSectionSeparator = 16
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// encode writes a value in the MaxMind DB data format. Unsigned integers are
+// written as uint32, or uint64 when they do not fit.
+func encode(value any) []byte {
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		out := control(typeMap, uint(len(v)))
+		for _, key := range keys {
+			out = append(out, encode(key)...)
+			out = append(out, encode(v[key])...)
+		}
+		return out
+	case []any:
+		out := control(typeArray, uint(len(v)))
+		for _, item := range v {
+			out = append(out, encode(item)...)
+		}
+		return out
+	case string:
+		return append(control(typeString, uint(len(v))), v...)
+	case int:
+		b := binary.BigEndian.AppendUint64(nil, uint64(v))
+		b = bytes.TrimLeft(b, "\x00")
+		if uint64(v) > 0xffffffff {
+			return append(control(typeUint64, uint(len(b))), b...)
+		}
+		return append(control(typeUint32, uint(len(b))), b...)
+	}
+	panic("unsupported test value")
+}
+
+func control(typ, size uint) []byte {
+	var ext []byte
+	switch {
+	case size >= 285:
+		ext = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	case size >= 29:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	}
+	var out []byte
+	if typ > 7 {
+		out = []byte{byte(size), byte(typ - 7)}
+	} else {
+		out = []byte{byte(typ<<5 | size)}
+	}
+	return append(out, ext...)
+}
+
+// pointerTo encodes a pointer to a data section offset below 2048.
+func pointerTo(offset uint) []byte {
+	return []byte{byte(typePointer<<5 | (offset>>8)&0x7), byte(offset)}
+}
+
+type testNetwork struct {
+	cidr   string
+	offset uint // into the data section
+}
+
+// testDB describes a database built by build.
+type testDB struct {
+	ipVersion  int
+	recordSize int
+	networks   []testNetwork
+	data       []byte
+	// ipv4Aliases adds the ::ffff:0:0/96 and 2002::/16 aliases of the IPv4
+	// subtree found in real IPv6 databases.
+	ipv4Aliases bool
+}
+
+type treeRecord struct {
+	node   int  // child node, when data is false
+	offset uint // data offset, when data is true
+	data   bool
+	set    bool
+}
+
+func prefixBits(t *testing.T, cidr string, ipVersion int) []byte {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones, _ := network.Mask.Size()
+	ip := network.IP
+	if v4 := ip.To4(); v4 != nil && len(network.Mask) == net.IPv4len && ipVersion == 6 {
+		ip = append(make(net.IP, 12), v4...)
+		ones += 96
+	}
+	bits := make([]byte, ones)
+	for i := range bits {
+		bits[i] = ip[i/8] >> (7 - uint(i%8)) & 1
+	}
+	return bits
+}
+
+func (db testDB) build(t *testing.T) []byte {
+	t.Helper()
+	nodes := [][2]treeRecord{{}}
+	insert := func(bits []byte, last treeRecord) {
+		node := 0
+		for i, bit := range bits {
+			if i == len(bits)-1 {
+				last.set = true
+				nodes[node][bit] = last
+				return
+			}
+			if !nodes[node][bit].set {
+				nodes = append(nodes, [2]treeRecord{})
+				nodes[node][bit] = treeRecord{node: len(nodes) - 1, set: true}
+			}
+			node = nodes[node][bit].node
+		}
+	}
+	for _, n := range db.networks {
+		insert(prefixBits(t, n.cidr, db.ipVersion), treeRecord{offset: n.offset, data: true})
+	}
+	if db.ipv4Aliases {
+		ipv4Start := 0
+		for i := 0; i < 96; i++ {
+			ipv4Start = nodes[ipv4Start][0].node
+		}
+		insert(prefixBits(t, "::ffff:0:0/96", 6), treeRecord{node: ipv4Start})
+		insert(prefixBits(t, "2002::/16", 6), treeRecord{node: ipv4Start})
+	}
+
+	count := uint(len(nodes))
+	value := func(r treeRecord) uint {
+		switch {
+		case !r.set:
+			return count
+		case r.data:
+			return count + dataSectionSeparator + r.offset
+		}
+		return uint(r.node)
+	}
+	var tree []byte
+	for _, node := range nodes {
+		left, right := value(node[0]), value(node[1])
+		switch db.recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0xf), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, uint32(left))
+			tree = binary.BigEndian.AppendUint32(tree, uint32(right))
+		}
+	}
+
+	out := append(tree, make([]byte, dataSectionSeparator)...)
+	out = append(out, db.data...)
+	out = append(out, metadataMarker...)
+	return append(out, encode(map[string]any{
+		"node_count":                  int(count),
+		"record_size":                 db.recordSize,
+		"ip_version":                  db.ipVersion,
+		"database_type":               "Test-Country",
+		"build_epoch":                 1700000000,
+		"binary_format_major_version": 2,
+		"binary_format_minor_version": 0,
+		"languages":                   []any{"en"},
+	})...)
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"country": map[string]any{"iso_code": code}}
+}
+
+// fixture returns a database with DE for 127.0.0.0/8, US for 10.0.0.0/8 and
+// FR for 2001:db8::/32, plus the offsets of the three records.
+func fixture(recordSize int) (testDB, [3]uint) {
+	var data []byte
+	var offsets [3]uint
+	for i, code := range []string{"DE", "US", "FR"} {
+		offsets[i] = uint(len(data))
+		data = append(data, encode(country(code))...)
+	}
+	return testDB{
+		ipVersion:  6,
+		recordSize: recordSize,
+		data:       data,
+		networks: []testNetwork{
+			{"127.0.0.0/8", offsets[0]},
+			{"10.0.0.0/8", offsets[1]},
+			{"2001:db8::/32", offsets[2]},
+		},
+		ipv4Aliases: true,
+	}, offsets
+}
+
+func writeDB(t *testing.T, path string, buf []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openDB loads a single database file built from buf.
+func openDB(t *testing.T, buf []byte) (*DB, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	writeDB(t, path, buf, time.Now())
+	db := New()
+	return db, db.Configure([]string{path}, 0, nil)
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Options struct {
@@ -19,6 +20,7 @@ type Options struct {
 
 type commandRunner interface {
 	CombinedOutput(command string, args ...string) ([]byte, error)
+	CombinedOutputWithInput(input string, command string, args ...string) ([]byte, error)
 }
 
 type sudoExecRunner struct{}
@@ -28,6 +30,12 @@ func (sudoExecRunner) CombinedOutput(command string, args ...string) ([]byte, er
 	return cmd.CombinedOutput()
 }
 
+func (sudoExecRunner) CombinedOutputWithInput(input string, command string, args ...string) ([]byte, error) {
+	cmd := exec.Command("sudo", append([]string{command}, args...)...)
+	cmd.Stdin = strings.NewReader(input)
+	return cmd.CombinedOutput()
+}
+
 type Manager struct {
 	Chain         string
 	ParentChains  []string
@@ -35,6 +43,9 @@ type Manager struct {
 	BaseRuleCount int
 	tables        []string
 	runner        commandRunner
+
+	geoMu     sync.Mutex
+	geoBlocks []string
 }
 
 func parseParentChains(value interface{}) []string {
@@ -196,6 +207,10 @@ func (m *Manager) Init() error {
 				return errors.New(errors.CodeIptablesInitError, fmt.Sprintf("Failed to apply base rules (%s): %v", table, err))
 			}
 		}
+
+		if err := m.reapplyGeoBlocks(table); err != nil {
+			return errors.New(errors.CodeIptablesInitError, fmt.Sprintf("Failed to apply GeoIP blocks (%s): %v", table, err))
+		}
 	}
 
 	return nil
@@ -209,6 +224,9 @@ func (m *Manager) Flush() error {
 		if err := m.applyBaseRules(table); err != nil {
 			return errors.New(errors.CodeIptablesCommandError, fmt.Sprintf("Failed to reapply base rules (%s): %v", table, err))
 		}
+		if err := m.reapplyGeoBlocks(table); err != nil {
+			return errors.New(errors.CodeIptablesCommandError, fmt.Sprintf("Failed to reapply GeoIP blocks (%s): %v", table, err))
+		}
 	}
 	return nil
 }
@@ -265,6 +283,8 @@ func (m *Manager) Destroy() error {
 
 		_ = m.runTable(table, "-F", m.Chain)
 		_ = m.runTable(table, "-X", m.Chain)
+		set, _ := m.geoSet(table)
+		_, _ = m.runner.CombinedOutput("ipset", "destroy", set)
 	}
 	return nil
 }
@@ -330,6 +350,105 @@ func (m *Manager) RemoveIPRule(ip string) error {
 	return nil
 }
 
+// geoSetMinSize is the smallest maxelem of a GeoIP set, the ipset default.
+const geoSetMinSize = 65536
+
+// geoSet returns the ipset holding the blocked networks of a table's family.
+func (m *Manager) geoSet(table string) (name string, family string) {
+	if table == "ip6tables" {
+		return m.Chain + "_GEO6", "inet6"
+	}
+	return m.Chain + "_GEO4", "inet"
+}
+
+func (m *Manager) geoRule(table string) []string {
+	set, _ := m.geoSet(table)
+	return []string{m.Chain, "-m", "set", "--match-set", set, "src", "-j", "DROP"}
+}
+
+// SetGeoBlocks replaces the networks dropped for GeoIP country blocks. They
+// are kept in an ipset per address family, matched by one rule at the end of
+// the managed chain, so allowed and blocked IPs take precedence. An empty
+// list removes the sets. Blocks set before Init are applied by Init.
+func (m *Manager) SetGeoBlocks(cidrs []string) error {
+	m.geoMu.Lock()
+	defer m.geoMu.Unlock()
+
+	m.geoBlocks = cidrs
+	for _, table := range m.tables {
+		if len(cidrs) == 0 {
+			m.removeGeoSet(table)
+			continue
+		}
+		if err := m.runTable(table, "-L", m.Chain, "-n"); err != nil {
+			continue
+		}
+		if err := m.applyGeoBlocks(table); err != nil {
+			return errors.New(errors.CodeIptablesCommandError, fmt.Sprintf("Failed to apply GeoIP blocks (%s): %v", table, err))
+		}
+	}
+	return nil
+}
+
+func (m *Manager) reapplyGeoBlocks(table string) error {
+	m.geoMu.Lock()
+	defer m.geoMu.Unlock()
+	if len(m.geoBlocks) == 0 {
+		return nil
+	}
+	return m.applyGeoBlocks(table)
+}
+
+// applyGeoBlocks loads the networks into a temporary set with ipset restore
+// and swaps it with the live one, so the block list is replaced in one step
+// and a country list with thousands of networks costs a single rule.
+func (m *Manager) applyGeoBlocks(table string) error {
+	set, family := m.geoSet(table)
+	tmp := set + "_NEW"
+	var cidrs []string
+	for _, cidr := range m.geoBlocks {
+		if strings.Contains(cidr, ":") == (family == "inet6") {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+
+	// Sets swap regardless of maxelem, but create -exist fails on a set with
+	// different settings, so the live set is only created when missing.
+	if _, err := m.runner.CombinedOutput("ipset", "list", "-name", set); err != nil {
+		if output, err := m.runner.CombinedOutput("ipset", "create", set, "hash:net", "family", family); err != nil {
+			return fmt.Errorf("ipset create failed: %s", string(output))
+		}
+	}
+	_, _ = m.runner.CombinedOutput("ipset", "destroy", tmp)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "create %s hash:net family %s maxelem %d\n", tmp, family, max(len(cidrs), geoSetMinSize))
+	for _, cidr := range cidrs {
+		fmt.Fprintf(&b, "add %s %s -exist\n", tmp, cidr)
+	}
+	fmt.Fprintf(&b, "swap %s %s\ndestroy %s\n", tmp, set, tmp)
+
+	if output, err := m.runner.CombinedOutputWithInput(b.String(), "ipset", "restore"); err != nil {
+		return fmt.Errorf("ipset restore failed: %s", string(output))
+	}
+	rule := m.geoRule(table)
+	if err := m.runTable(table, append([]string{"-C"}, rule...)...); err != nil {
+		return m.runTable(table, append([]string{"-A"}, rule...)...)
+	}
+	return nil
+}
+
+func (m *Manager) removeGeoSet(table string) {
+	rule := m.geoRule(table)
+	for {
+		if err := m.runTable(table, append([]string{"-D"}, rule...)...); err != nil {
+			break
+		}
+	}
+	set, _ := m.geoSet(table)
+	_, _ = m.runner.CombinedOutput("ipset", "destroy", set)
+}
+
 type Rule struct {
 	IP     string `json:"ip"`
 	Action string `json:"action"` // ACCEPT or DROP
@@ -18,11 +18,13 @@ type LogEntry struct {
 	Duration  string `json:"duration"`
 	UserAgent string `json:"user_agent"`
 	RemoteIP  string `json:"remote_ip"`
+	Country   string `json:"country,omitempty"`
 }
 
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status  int
+	country string
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -44,6 +46,26 @@ func (rw *responseWriter) Flush() {
 	}
 }
 
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// SetLogCountry records the client country in the access log entry of the
+// request served through w.
+func SetLogCountry(w http.ResponseWriter, country string) {
+	for {
+		switch rw := w.(type) {
+		case *responseWriter:
+			rw.country = country
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -62,6 +84,7 @@ func Logger(next http.Handler) http.Handler {
 			Duration:  duration.String(),
 			UserAgent: r.UserAgent(),
 			RemoteIP:  r.RemoteAddr,
+			Country:   rw.country,
 		}
 
 		if rw.status >= 400 {
@@ -2,24 +2,168 @@ package models
 
 type Rule struct {
 	Path        string `json:"path" example:"/api"`                    // Path prefix to match (e.g., "/api")
-	Target      string `json:"target" example:"http://localhost:8080"` // Target URL (e.g., "http://localhost:7996")
+	Target      string `json:"target" example:"http://localhost:8080"` // Target URL (e.g., "http://localhost:7996"). Only used by proxy rules.
 	UseAuth     bool   `json:"use_auth" example:"false"`               // If true, invokes global authentication check before proxying.
 	StripPath   bool   `json:"strip_path" example:"true"`              // If true, strips the Path prefix from the request before forwarding.
 	RewriteHTML bool   `json:"rewrite_html" example:"true"`            // If true, rewrites absolute paths in HTML response to include Path prefix.
 	UseRootMode bool   `json:"use_root_mode" example:"false"`          // If true, sets cookie and redirects matched path to /.
+	NoFallback  bool   `json:"no_fallback,omitempty" example:"false"`  // If true, the rule is only selected by its own path, never by cookie or Referer fallback.
+	InjectShim  bool   `json:"inject_shim,omitempty" example:"false"`  // If true, injects a script into HTML pages that keeps fetch/XHR/WebSocket/EventSource/history URLs under Path.
+
+	MatchType      string `json:"match_type,omitempty" example:"prefix"`           // How requests are matched: "prefix" (default, uses Path), "regex" or "glob" (uses MatchPattern).
+	MatchPattern   string `json:"match_pattern,omitempty" example:"/app/*/api/**"` // Regex or glob pattern used when MatchType is "regex" or "glob". Path stays the public prefix.
+	RewriteRegex   string `json:"rewrite_regex,omitempty" example:"^/app/(.*)$"`   // Regex applied to the request path before forwarding. Takes precedence over StripPath when it matches.
+	RewriteTarget  string `json:"rewrite_target,omitempty" example:"/v2/$1"`       // Replacement for RewriteRegex, supports $1 / ${name} group references.
+	UpstreamPrefix string `json:"upstream_prefix,omitempty" example:"/v2"`         // Prefix added to the upstream path after stripping or rewriting.
+
+	RequestHeaders  *HeaderOps `json:"request_headers,omitempty"`  // Header operations applied to the request sent upstream.
+	ResponseHeaders *HeaderOps `json:"response_headers,omitempty"` // Header operations applied to the response sent to the client.
+
+	Methods      []string          `json:"methods,omitempty" example:"GET,HEAD"`              // If set, only these HTTP methods match (GET also allows HEAD).
+	MatchHeaders map[string]string `json:"match_headers,omitempty" example:"X-API-Version:2"` // Request headers that must be present; an empty value or "*" only checks presence.
+	MatchQuery   map[string]string `json:"match_query,omitempty" example:"beta:1"`            // Query parameters that must be present, same value semantics as MatchHeaders.
+	MatchCookies map[string]string `json:"match_cookies,omitempty" example:"channel:beta"`    // Cookies that must be present, same value semantics as MatchHeaders.
+	Priority     int               `json:"priority,omitempty" example:"0"`                    // Breaks ties between rules with the same match length: higher priority wins, then the rule with more predicates.
+
+	Type         string `json:"type,omitempty" example:"proxy"`                               // Rule type: "proxy" (default), "redirect", "static", "maintenance" or "files".
+	RedirectURL  string `json:"redirect_url,omitempty" example:"https://example.com{suffix}"` // Redirect rules: location template, supports {path}, {suffix}, {query}, {request_uri}, {host}, {scheme}, {rule_path} and regex groups ($1).
+	RedirectCode int    `json:"redirect_code,omitempty" example:"302"`                        // Redirect rules: 301, 302, 307 or 308 (default 302).
+	StatusCode   int    `json:"status_code,omitempty" example:"200"`                          // Static and maintenance rules: response status (default 200 and 503).
+	Body         string `json:"body,omitempty" example:"ok"`                                  // Static rules: response body. Maintenance rules: message shown on the page.
+	ContentType  string `json:"content_type,omitempty" example:"text/plain"`                  // Static rules: Content-Type (default text/plain; charset=utf-8).
+
+	Root             string `json:"root,omitempty" example:"/var/www/app"`       // Files rules: absolute directory served under Path.
+	DirectoryListing bool   `json:"directory_listing,omitempty" example:"false"` // Files rules: list directories that have no index.html.
+	SPAFallback      bool   `json:"spa_fallback,omitempty" example:"true"`       // Files rules: serve Root/index.html for missing page requests.
+
+	TLS *UpstreamTLS `json:"tls,omitempty"` // TLS settings for https:// and wss:// targets.
+
+	CookieNamespace string `json:"cookie_namespace,omitempty" example:"app1"` // Proxy rules: prefix cookie names set by the upstream with "<namespace>__", forward only them (unprefixed) plus shared cookies, and drop other namespaces and auth cookies.
+
+	DialTimeout           int `json:"dial_timeout,omitempty" example:"6"`             // Proxy rules: upstream connect timeout in seconds (default 6).
+	ResponseHeaderTimeout int `json:"response_header_timeout,omitempty" example:"10"` // Proxy rules: seconds to wait for upstream response headers (default 10, -1 waits forever).
+	IdleTimeout           int `json:"idle_timeout,omitempty" example:"90"`            // Proxy rules: seconds an idle upstream connection is kept for reuse (default 90).
+	Timeout               int `json:"timeout,omitempty" example:"0"`                  // Proxy rules: total request timeout in seconds including the body (default 0, no limit). Not applied to WebSocket upgrades.
+	FlushInterval         int `json:"flush_interval,omitempty" example:"-1"`          // Proxy rules: response flush interval in milliseconds, -1 flushes after every write (SSE, long polling).
+
+	RateLimit *RateLimit `json:"rate_limit,omitempty"` // Requests per client allowed on this rule, applied in addition to the global limit.
+
+	MaxInFlight  int `json:"max_in_flight,omitempty" example:"4"`  // Maximum requests served by this rule at once, 0 means no limit. Further requests wait in a FIFO queue.
+	MaxQueue     int `json:"max_queue,omitempty" example:"50"`     // Requests allowed to wait when MaxInFlight is reached (default 0, answer 503 at once).
+	QueueTimeout int `json:"queue_timeout,omitempty" example:"10"` // Seconds a request may wait in the queue before it is answered with 503 (default 10).
+
+	MaxBodySize    int64 `json:"max_body_size,omitempty" example:"10485760"` // Maximum request body in bytes, answered with 413 (default: global client limit, -1 no limit).
+	MaxHeaderBytes int   `json:"max_header_bytes,omitempty" example:"16384"` // Maximum size of the request line and headers in bytes, answered with 431 (default: global client limit, -1 no limit).
+	ReadTimeout    int   `json:"read_timeout,omitempty" example:"60"`        // Seconds allowed to receive the request body, answered with 408 (default: global client limit, -1 no limit). Not applied to WebSocket upgrades.
+
+	IPFilter  *IPFilter  `json:"ip_filter,omitempty"`  // Client addresses allowed or denied on this rule, checked after the global filter.
+	GeoFilter *GeoFilter `json:"geo_filter,omitempty"` // Client countries and autonomous systems allowed or denied on this rule, resolved from the GeoIP databases.
+}
+
+// IPFilter restricts access by client address. Deny entries win over allow
+// entries; when Allow is set, only matching clients are admitted.
+type IPFilter struct {
+	Allow  []string `json:"allow,omitempty" example:"10.8.0.0/16"` // Client IPs or CIDRs admitted, empty admits everyone not denied
+	Deny   []string `json:"deny,omitempty" example:"10.8.3.0/24"`  // Client IPs or CIDRs rejected
+	Action string   `json:"action,omitempty" example:"forbid"`     // Response to denied clients: "forbid" (default, 403 page) or "drop" (connection closed without a response)
+}
+
+// RateLimit is a token bucket per client: Rate tokens are added per second up
+// to Burst, and each request takes one.
+type RateLimit struct {
+	Rate  float64 `json:"rate" example:"10"`  // Requests per second, 0 disables the limit
+	Burst int     `json:"burst" example:"20"` // Bucket size, requests allowed at once (default: rate rounded up, at least 1)
+	Key   string  `json:"key" example:"ip"`   // Client key: "ip" (default), "identity" (user reported by the auth service, falls back to the IP) or "header:<name>" (only read from requests forwarded by trusted proxies, falls back to the IP)
+}
+
+// GeoFilter restricts access by the country or autonomous system of the
+// client. Deny entries win over allow entries; when an allow list is set,
+// only clients matching one of the allow lists are admitted.
+type GeoFilter struct {
+	AllowCountries []string `json:"allow_countries,omitempty" example:"DE,AT,CH"` // ISO 3166-1 alpha-2 country codes admitted
+	DenyCountries  []string `json:"deny_countries,omitempty" example:"KP"`        // Country codes rejected
+	AllowASNs      []uint   `json:"allow_asns,omitempty" example:"3320"`          // Autonomous system numbers admitted
+	DenyASNs       []uint   `json:"deny_asns,omitempty" example:"64496"`          // Autonomous system numbers rejected
+	AllowUnknown   bool     `json:"allow_unknown,omitempty" example:"true"`       // If true, clients the databases do not know (private addresses, no database loaded) pass the allow lists
+	Action         string   `json:"action,omitempty" example:"forbid"`            // Response to denied clients: "forbid" (default, 403 page) or "drop" (connection closed without a response)
+}
+
+// UpstreamTLS configures how the proxy verifies and authenticates to an
+// https upstream.
+type UpstreamTLS struct {
+	CA                 string `json:"ca,omitempty" example:"-----BEGIN CERTIFICATE-----\n..."` // PEM CA bundle trusted for the upstream certificate (replaces system roots).
+	Cert               string `json:"cert,omitempty"`                                          // PEM client certificate presented to the upstream (mTLS).
+	Key                string `json:"key,omitempty"`                                           // PEM private key for Cert. Never returned; omit it to keep the stored key for an unchanged cert.
+	ServerName         string `json:"server_name,omitempty" example:"app.internal"`            // SNI and verification name, defaults to the target host.
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" example:"false"`          // Disable upstream certificate verification.
+}
+
+// HeaderOps describes declarative header changes. Values may use the
+// placeholders {client_ip}, {rule_path}, {user}, {host} and {scheme}.
+// Remove is applied first, then Set, then Add.
+type HeaderOps struct {
+	Set    map[string]string `json:"set,omitempty" example:"X-Forwarded-Prefix:{rule_path}"` // Headers to set, replacing existing values.
+	Add    map[string]string `json:"add,omitempty"`                                          // Headers to append.
+	Remove []string          `json:"remove,omitempty" example:"Server,X-Powered-By"`         // Headers to delete.
 }
 
 type AuthConfig struct {
-	AuthPort     int    `json:"auth_port" example:"3000"`                    // Local Auth Service Port
-	AuthURL      string `json:"auth_url" example:"/api/auth/verify"`         // Relative Verify URL (default /api/auth/verify)
-	LoginURL     string `json:"login_url" example:"/login"`                  // Relative Login URL (default /login)
-	LogoutURL    string `json:"logout_url" example:"/api/auth/logout"`       // Relative Logout URL (default /api/auth/logout)
-	PreflightURL string `json:"preflight_url" example:"/api/auth/preflight"` // Relative Preflight URL (default /api/auth/preflight)
+	AuthPort     int      `json:"auth_port" example:"3000"`                    // Local Auth Service Port
+	AuthURL      string   `json:"auth_url" example:"/api/auth/verify"`         // Relative Verify URL (default /api/auth/verify)
+	LoginURL     string   `json:"login_url" example:"/login"`                  // Relative Login URL (default /login)
+	LogoutURL    string   `json:"logout_url" example:"/api/auth/logout"`       // Relative Logout URL (default /api/auth/logout)
+	PreflightURL string   `json:"preflight_url" example:"/api/auth/preflight"` // Relative Preflight URL (default /api/auth/preflight)
+	AuthCookies  []string `json:"auth_cookies,omitempty" example:"session"`    // Cookies owned by the auth service, never forwarded to rules with a cookie_namespace. Cookies set through /__auth__/ are detected automatically.
+}
+
+// FallbackConfig controls how requests that match no rule path may still be
+// routed to a rule.
+type FallbackConfig struct {
+	Strategies  []string `json:"strategies" example:"cookie,referer"` // Ordered fallback strategies: "cookie" (__proxy_path cookie) and "referer". Empty disables fallback routing.
+	Strict      bool     `json:"strict" example:"false"`              // If true, all strategies that find a rule must agree on it, otherwise the request is not routed.
+	DebugHeader bool     `json:"debug_header" example:"false"`        // If true, responses carry X-Proxy-Route with the strategy and rule that handled them.
+}
+
+// ClientLimits protects the proxy and upstreams from oversized requests and
+// slow or greedy clients. Rules may override the request limits.
+type ClientLimits struct {
+	MaxBodySize    int64 `json:"max_body_size" example:"0"`    // Default maximum request body in bytes, 0 means no limit
+	MaxHeaderBytes int   `json:"max_header_bytes" example:"0"` // Default maximum size of the request line and headers in bytes, 0 means no limit (the server always caps headers at 1 MB)
+	ReadTimeout    int   `json:"read_timeout" example:"0"`     // Default seconds allowed to receive a request body, 0 means no limit
+	MaxConnsPerIP  int   `json:"max_conns_per_ip" example:"0"` // Maximum concurrent connections per client IP across all proxy ports, 0 means no limit. Trusted proxies are exempt.
+}
+
+// GeoIPConfig points to MaxMind GeoIP2/GeoLite2 or DB-IP .mmdb databases.
+// The files are reloaded when they change on disk.
+type GeoIPConfig struct {
+	CountryDB              string   `json:"country_db" example:"/var/lib/GeoIP/GeoLite2-Country.mmdb"` // Country database, may also contain ASN data
+	ASNDB                  string   `json:"asn_db" example:"/var/lib/GeoIP/GeoLite2-ASN.mmdb"`         // ASN database, optional
+	ReloadInterval         int      `json:"reload_interval,omitempty" example:"60"`                    // Seconds between checks of the files for changes (default 60)
+	IptablesBlockCountries []string `json:"iptables_block_countries,omitempty" example:"KP"`           // Countries whose networks are dropped in the managed iptables chain
+}
+
+// TrustedProxy describes a source network in front of the proxy.
+type TrustedProxy struct {
+	CIDR          string `json:"cidr" example:"10.0.0.0/8"`    // Source address or network of the proxy / load balancer
+	ProxyProtocol string `json:"proxy_protocol" example:"use"` // PROXY protocol header policy: "use" (default, honored if present), "require", "ignore" (accepted but not honored) or "reject"
+	TrustHeaders  bool   `json:"trust_headers" example:"true"` // If true, X-Forwarded-For / X-Real-IP sent from this network are honored
+}
+
+// TrustedProxyConfig decides which peers may report client addresses.
+type TrustedProxyConfig struct {
+	Proxies       []TrustedProxy `json:"proxies"`                         // Trusted networks, the most specific match wins
+	ProxyProtocol string         `json:"proxy_protocol" example:"ignore"` // PROXY protocol header policy for all other sources: "ignore" (default) or "reject"
 }
 
+// PortConfig describes an additional proxy listener with its own rule set.
 type PortConfig struct {
-	Port  int    `json:"port"`
-	Rules []Rule `json:"rules"`
+	Port               int      `json:"port" example:"8443"`                             // Listener port, must differ from the main proxy port and the admin port
+	Rules              []Rule   `json:"rules"`                                           // Rules served on this port
+	Bind               []string `json:"bind,omitempty" example:"0.0.0.0,::"`             // Bind addresses of this port (IP literals, "::" is dual-stack). The global proxy bind addresses are used when empty.
+	DefaultRoute       string   `json:"default_route" example:"/__select__"`             // Default route of this port (default /__select__)
+	ProxyProtocolForce bool     `json:"proxy_protocol_force" example:"false"`            // If true, this port only binds loopback (127.0.0.1 / ::1) and loopback peers are trusted proxies
+	SSLCert            string   `json:"ssl_cert,omitempty" example:"-----BEGIN CERT..."` // PEM certificate for this port. The global certificate is used when empty.
+	SSLKey             string   `json:"ssl_key,omitempty" example:"-----BEGIN KEY..."`   // PEM private key. Never returned; omit it to keep the stored key for an unchanged ssl_cert.
 }
 
 type SSLInfo struct {
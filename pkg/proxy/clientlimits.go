@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func validateRuleClientLimits(rule models.Rule) error {
+	if rule.MaxBodySize < -1 {
+		return fmt.Errorf("max_body_size must be -1, 0 or positive")
+	}
+	if rule.MaxHeaderBytes < -1 {
+		return fmt.Errorf("max_header_bytes must be -1, 0 or positive")
+	}
+	if rule.ReadTimeout < -1 {
+		return fmt.Errorf("read_timeout must be -1, 0 or positive")
+	}
+	return nil
+}
+
+func validateClientLimits(limits models.ClientLimits) error {
+	if limits.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	if limits.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max_header_bytes must not be negative")
+	}
+	if limits.ReadTimeout < 0 {
+		return fmt.Errorf("read_timeout must not be negative")
+	}
+	if limits.MaxConnsPerIP < 0 {
+		return fmt.Errorf("max_conns_per_ip must not be negative")
+	}
+	return nil
+}
+
+type requestLimits struct {
+	maxBodySize    int64
+	maxHeaderBytes int
+	readTimeout    time.Duration
+}
+
+// effectiveRequestLimits applies the rule overrides to the global limits.
+// A rule value of -1 lifts the global limit.
+func effectiveRequestLimits(global models.ClientLimits, rule *models.Rule) requestLimits {
+	limits := requestLimits{
+		maxBodySize:    global.MaxBodySize,
+		maxHeaderBytes: global.MaxHeaderBytes,
+		readTimeout:    time.Duration(global.ReadTimeout) * time.Second,
+	}
+	if rule == nil {
+		return limits
+	}
+	if rule.MaxBodySize != 0 {
+		limits.maxBodySize = max(rule.MaxBodySize, 0)
+	}
+	if rule.MaxHeaderBytes != 0 {
+		limits.maxHeaderBytes = max(rule.MaxHeaderBytes, 0)
+	}
+	if rule.ReadTimeout != 0 {
+		limits.readTimeout = time.Duration(max(rule.ReadTimeout, 0)) * time.Second
+	}
+	return limits
+}
+
+// requestHeaderBytes approximates the size of the request line and headers
+// as sent by the client.
+func requestHeaderBytes(r *http.Request) int {
+	n := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	n += len(r.Host) + len("Host: \r\n")
+	for name, values := range r.Header {
+		for _, value := range values {
+			n += len(name) + len(value) + 4
+		}
+	}
+	return n
+}
+
+type clientBodyKey struct{}
+
+// clientBody records why reading the request body failed, so a proxy error
+// caused by the client can be answered with 413 or 408 instead of 502.
+type clientBody struct {
+	io.ReadCloser
+	err         atomic.Value
+	onEOF       func()
+	readTimeout time.Duration
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && b.onEOF != nil {
+		b.onEOF()
+		b.onEOF = nil
+	} else if err != nil && err != io.EOF {
+		b.err.CompareAndSwap(nil, err)
+	}
+	return n, err
+}
+
+// enforceRequestLimits rejects requests whose headers or declared body are
+// too large and limits reading the rest of the body. The read timeout only
+// starts with startBodyReadDeadline. It returns false when the request has
+// been answered.
+func (h *Handler) enforceRequestLimits(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, rule *models.Rule) (*http.Request, bool) {
+	limits := effectiveRequestLimits(snapshot.clientLimits, rule)
+
+	if limits.maxHeaderBytes > 0 && requestHeaderBytes(r) > limits.maxHeaderBytes {
+		atomic.AddUint64(&h.trafficHeadersTooLarge, 1)
+		response.ProxyError(w, r, errors.CodeHeadersTooLarge, fmt.Sprintf("Request headers exceed %d bytes", limits.maxHeaderBytes), snapshot.rules)
+		return r, false
+	}
+	if limits.maxBodySize > 0 && r.ContentLength > limits.maxBodySize {
+		atomic.AddUint64(&h.trafficBodyTooLarge, 1)
+		w.Header().Set("Connection", "close")
+		response.ProxyError(w, r, errors.CodeRequestTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limits.maxBodySize), snapshot.rules)
+		return r, false
+	}
+	// The body may be wrapped for traffic accounting, so check the length
+	// as well. Nothing reads an empty body, so its deadline would never be
+	// lifted.
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return r, true
+	}
+
+	if limits.maxBodySize <= 0 && limits.readTimeout <= 0 {
+		return r, true
+	}
+
+	if limits.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limits.maxBodySize)
+	}
+	body := &clientBody{ReadCloser: r.Body}
+	if !isUpgradeRequest(r) {
+		body.readTimeout = limits.readTimeout
+	}
+	r.Body = body
+	return r.WithContext(context.WithValue(r.Context(), clientBodyKey{}, body)), true
+}
+
+// startBodyReadDeadline starts the read timeout of the request body. It is
+// called right before the body is handed on, so time spent in auth checks or
+// the concurrency queue does not count. The deadline covers the body only and
+// is lifted once the body has been read, so slow or streamed responses are
+// not cut off.
+func startBodyReadDeadline(w http.ResponseWriter, r *http.Request) {
+	body, _ := r.Context().Value(clientBodyKey{}).(*clientBody)
+	if body == nil || body.readTimeout <= 0 {
+		return
+	}
+	rc := http.NewResponseController(w)
+	if rc.SetReadDeadline(time.Now().Add(body.readTimeout)) == nil {
+		body.onEOF = func() { _ = rc.SetReadDeadline(time.Time{}) }
+	}
+	body.readTimeout = 0
+}
+
+// handleClientBodyError answers a failed proxy request whose body could not
+// be read because of a client limit.
+func (h *Handler) handleClientBodyError(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot) bool {
+	body, _ := r.Context().Value(clientBodyKey{}).(*clientBody)
+	if body == nil {
+		return false
+	}
+	err, _ := body.err.Load().(error)
+	if err == nil {
+		return false
+	}
+
+	var tooLarge *http.MaxBytesError
+	if stderrors.As(err, &tooLarge) {
+		atomic.AddUint64(&h.trafficBodyTooLarge, 1)
+		response.ProxyError(w, r, errors.CodeRequestTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), snapshot.rules)
+		return true
+	}
+	var netErr net.Error
+	if stderrors.As(err, &netErr) && netErr.Timeout() {
+		atomic.AddUint64(&h.trafficReadTimeouts, 1)
+		w.Header().Set("Connection", "close")
+		response.ProxyError(w, r, errors.CodeRequestTimeout, "Timed out reading the request body", snapshot.rules)
+		return true
+	}
+	return false
+}
+
+// ipConnCounter counts open connections per client IP.
+type ipConnCounter struct {
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func (c *ipConnCounter) acquire(ip string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[ip] >= limit {
+		return false
+	}
+	if c.conns == nil {
+		c.conns = make(map[string]int)
+	}
+	c.conns[ip]++
+	return true
+}
+
+func (c *ipConnCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[ip] <= 1 {
+		delete(c.conns, ip)
+		return
+	}
+	c.conns[ip]--
+}
+
+type connLimitListener struct {
+	net.Listener
+	handler *Handler
+	port    int
+}
+
+// LimitConnections enforces max_conns_per_ip on a proxy listener. It counts
+// direct peers before any PROXY protocol header is read, so connections from
+// trusted proxies, which carry many clients, are not limited.
+func (h *Handler) LimitConnections(l net.Listener, port int) net.Listener {
+	return &connLimitListener{Listener: l, handler: h, port: port}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		limit := l.handler.GetClientLimits().MaxConnsPerIP
+		addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if limit <= 0 || !ok {
+			return conn, nil
+		}
+		if _, trusted := l.handler.getTrustedProxies().lookup(addr.IP, l.handler.GetListenerProxyProtocolForce(l.port)); trusted {
+			return conn, nil
+		}
+		ip := normalizeClientIP(addr.IP.String())
+		if !l.handler.ipConns.acquire(ip, limit) {
+			atomic.AddUint64(&l.handler.trafficConnsRejected, 1)
+			_ = conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { l.handler.ipConns.release(ip) }}, nil
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func (h *Handler) GetClientLimits() models.ClientLimits {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ClientLimits
+}
+
+func (h *Handler) SetClientLimits(limits models.ClientLimits) error {
+	if err := validateClientLimits(limits); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ClientLimits = limits
+	h.saveConfigLocked()
+	return nil
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"container/list"
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultQueueTimeout = 10 * time.Second
+
+func validateRuleConcurrency(rule models.Rule) error {
+	if rule.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must not be negative")
+	}
+	if rule.MaxQueue < 0 {
+		return fmt.Errorf("max_queue must not be negative")
+	}
+	if rule.QueueTimeout < 0 {
+		return fmt.Errorf("queue_timeout must not be negative")
+	}
+	if rule.MaxInFlight == 0 && (rule.MaxQueue > 0 || rule.QueueTimeout > 0) {
+		return fmt.Errorf("max_queue and queue_timeout require max_in_flight")
+	}
+	return nil
+}
+
+type concurrencySettings struct {
+	maxInFlight  int
+	maxQueue     int
+	queueTimeout time.Duration
+}
+
+func ruleConcurrencySettings(rule models.Rule) concurrencySettings {
+	return concurrencySettings{
+		maxInFlight:  rule.MaxInFlight,
+		maxQueue:     rule.MaxQueue,
+		queueTimeout: secondsOr(rule.QueueTimeout, defaultQueueTimeout),
+	}
+}
+
+var (
+	errQueueFull    = fmt.Errorf("queue is full")
+	errQueueTimeout = fmt.Errorf("queue timeout")
+)
+
+// concurrencyLimiter admits up to maxInFlight requests and queues the rest
+// in arrival order. A released slot is handed directly to the oldest waiter.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	settings concurrencySettings
+	port     int
+	path     string
+	inFlight int
+	queue    list.List // of chan struct{}, closed when the waiter owns a slot
+
+	admitted  uint64
+	waited    uint64
+	rejected  uint64
+	timedOut  uint64
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+// acquire waits for a slot. It returns errQueueFull or errQueueTimeout when
+// the request must be rejected, or the context error if the client left.
+func (l *concurrencyLimiter) acquire(r *http.Request) (func(), error) {
+	l.mu.Lock()
+	if l.inFlight < l.settings.maxInFlight {
+		l.inFlight++
+		l.admitted++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if l.queue.Len() >= l.settings.maxQueue {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, errQueueFull
+	}
+	ready := make(chan struct{})
+	elem := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(l.settings.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-r.Context().Done():
+		err = r.Context().Err()
+	}
+	wait := time.Since(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		select {
+		case <-ready:
+			// The slot was handed over while giving up, pass it on.
+			l.releaseLocked()
+		default:
+			l.queue.Remove(elem)
+		}
+		if err == errQueueTimeout {
+			l.timedOut++
+		}
+		return nil, err
+	}
+	l.admitted++
+	l.waited++
+	l.waitTotal += wait
+	if wait > l.waitMax {
+		l.waitMax = wait
+	}
+	return l.release, nil
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *concurrencyLimiter) releaseLocked() {
+	if front := l.queue.Front(); front != nil {
+		l.queue.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.inFlight--
+}
+
+type ConcurrencyStats struct {
+	Port         int     `json:"port,omitempty"` // Listener port of the rule, 0 for the main proxy port
+	Path         string  `json:"path"`
+	MaxInFlight  int     `json:"max_in_flight"`
+	MaxQueue     int     `json:"max_queue"`
+	QueueTimeout int     `json:"queue_timeout"`
+	InFlight     int     `json:"in_flight"`
+	Queued       int     `json:"queued"`      // Requests waiting right now
+	Admitted     uint64  `json:"admitted"`    // Requests that got a slot
+	Waited       uint64  `json:"waited"`      // Admitted requests that had to queue first
+	Rejected     uint64  `json:"rejected"`    // Requests answered with 503 because the queue was full
+	TimedOut     uint64  `json:"timed_out"`   // Requests answered with 503 after queue_timeout
+	AvgWaitMs    float64 `json:"avg_wait_ms"` // Average queue wait of requests that waited
+	MaxWaitMs    float64 `json:"max_wait_ms"`
+}
+
+func (l *concurrencyLimiter) stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := ConcurrencyStats{
+		Port:         l.port,
+		Path:         l.path,
+		MaxInFlight:  l.settings.maxInFlight,
+		MaxQueue:     l.settings.maxQueue,
+		QueueTimeout: int(l.settings.queueTimeout / time.Second),
+		InFlight:     l.inFlight,
+		Queued:       l.queue.Len(),
+		Admitted:     l.admitted,
+		Waited:       l.waited,
+		Rejected:     l.rejected,
+		TimedOut:     l.timedOut,
+		MaxWaitMs:    float64(l.waitMax) / float64(time.Millisecond),
+	}
+	if l.waited > 0 {
+		s.AvgWaitMs = float64(l.waitTotal) / float64(l.waited) / float64(time.Millisecond)
+	}
+	return s
+}
+
+// concurrencyLimiterPool keeps one limiter per rule. Changing the settings
+// of a rule starts a new limiter; requests admitted by the old one finish
+// against it.
+type concurrencyLimiterPool struct {
+	mu      sync.Mutex
+	entries map[string]*concurrencyLimiter
+}
+
+func newConcurrencyLimiterPool() *concurrencyLimiterPool {
+	return &concurrencyLimiterPool{entries: make(map[string]*concurrencyLimiter)}
+}
+
+func (p *concurrencyLimiterPool) get(id string, settings concurrencySettings, port int, path string) *concurrencyLimiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.entries[id]; ok && l.settings == settings && l.path == path {
+		return l
+	}
+	l := &concurrencyLimiter{settings: settings, port: port, path: path}
+	p.entries[id] = l
+	return l
+}
+
+func (p *concurrencyLimiterPool) retain(ids map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.entries {
+		if _, ok := ids[id]; !ok {
+			delete(p.entries, id)
+		}
+	}
+}
+
+// queued returns the number of requests waiting in all queues.
+func (p *concurrencyLimiterPool) queued() int64 {
+	p.mu.Lock()
+	limiters := make([]*concurrencyLimiter, 0, len(p.entries))
+	for _, l := range p.entries {
+		limiters = append(limiters, l)
+	}
+	p.mu.Unlock()
+
+	var n int64
+	for _, l := range limiters {
+		l.mu.Lock()
+		n += int64(l.queue.Len())
+		l.mu.Unlock()
+	}
+	return n
+}
+
+func (p *concurrencyLimiterPool) stats() []ConcurrencyStats {
+	p.mu.Lock()
+	limiters := make([]*concurrencyLimiter, 0, len(p.entries))
+	for _, l := range p.entries {
+		limiters = append(limiters, l)
+	}
+	p.mu.Unlock()
+
+	out := make([]ConcurrencyStats, 0, len(limiters))
+	for _, l := range limiters {
+		out = append(out, l.stats())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Port != out[j].Port {
+			return out[i].Port < out[j].Port
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// acquireRuleSlot waits for a free slot of the rule. When it returns false
+// the request has been answered, or the client is gone.
+func (h *Handler) acquireRuleSlot(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, rule models.Rule) (func(), bool) {
+	if rule.MaxInFlight <= 0 {
+		return func() {}, true
+	}
+	port, _ := requestListenerPort(r)
+	limiter := h.concurrencyLimiters.get(ruleLimiterID(port, rule), ruleConcurrencySettings(rule), port, rule.Path)
+
+	release, err := limiter.acquire(r)
+	if err == nil {
+		return release, true
+	}
+
+	switch err {
+	case errQueueFull:
+		atomic.AddUint64(&h.trafficOverloaded, 1)
+		response.ProxyError(w, r, errors.CodeProxyOverloaded, "Service is busy, too many requests are waiting", snapshot.rules)
+	case errQueueTimeout:
+		atomic.AddUint64(&h.trafficOverloaded, 1)
+		response.ProxyError(w, r, errors.CodeProxyOverloaded, "Service is busy, timed out waiting for a free slot", snapshot.rules)
+	}
+	return nil, false
+}
+
+func (h *Handler) GetConcurrencyStats() []ConcurrencyStats {
+	return h.concurrencyLimiters.stats()
+}
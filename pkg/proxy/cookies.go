@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var cookieNamespacePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// Browsers only honour these prefixes at the very start of a cookie name, so
+// the namespace goes after them.
+var cookieSecurePrefixes = []string{"__Host-", "__Secure-"}
+
+const proxyPathCookie = "__proxy_path"
+
+func validateCookieNamespace(namespace string) error {
+	if namespace != "" && !cookieNamespacePattern.MatchString(namespace) {
+		return fmt.Errorf("cookie_namespace may only contain letters, digits and '-'")
+	}
+	return nil
+}
+
+func namespaceCookieName(name, namespace string) string {
+	for _, prefix := range cookieSecurePrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return prefix + namespace + "__" + rest
+		}
+	}
+	return namespace + "__" + name
+}
+
+func stripCookieNamespace(name, namespace string) (string, bool) {
+	for _, prefix := range cookieSecurePrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			rest, ok = strings.CutPrefix(rest, namespace+"__")
+			return prefix + rest, ok
+		}
+	}
+	return strings.CutPrefix(name, namespace+"__")
+}
+
+func (h *Handler) learnAuthCookies(resp *http.Response) {
+	for _, cookie := range resp.Cookies() {
+		h.authCookieNames.Store(cookie.Name, struct{}{})
+	}
+}
+
+func (h *Handler) isAuthCookie(name string, authConfig models.AuthConfig) bool {
+	for _, configured := range authConfig.AuthCookies {
+		if configured == name {
+			return true
+		}
+	}
+	_, ok := h.authCookieNames.Load(name)
+	return ok
+}
+
+// filterUpstreamCookies rewrites the Cookie header sent to a rule's upstream.
+// Cookies in other rules' namespaces are always dropped. For a namespaced
+// rule its own cookies are forwarded under their original names and auth
+// service cookies are dropped as well.
+func (h *Handler) filterUpstreamCookies(header http.Header, rule models.Rule, rules []models.Rule, authConfig models.AuthConfig) {
+	var others []string
+	for _, other := range rules {
+		if other.CookieNamespace != "" && other.CookieNamespace != rule.CookieNamespace {
+			others = append(others, other.CookieNamespace)
+		}
+	}
+	if rule.CookieNamespace == "" && len(others) == 0 {
+		return
+	}
+	lines := header.Values("Cookie")
+	if len(lines) == 0 {
+		return
+	}
+
+	// Pairs are split by hand and forwarded byte for byte: net/http drops
+	// cookies whose values it considers invalid and re-quotes others.
+	type cookiePair struct{ name, pair string }
+	var own, shared []cookiePair
+	changed := false
+	for _, line := range lines {
+		for _, pair := range strings.Split(line, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			rawName, value, hasValue := strings.Cut(pair, "=")
+			name := strings.TrimSpace(rawName)
+			if rule.CookieNamespace != "" {
+				if stripped, ok := stripCookieNamespace(name, rule.CookieNamespace); ok {
+					renamed := stripped
+					if hasValue {
+						renamed += "=" + value
+					}
+					own = append(own, cookiePair{name: stripped, pair: renamed})
+					changed = true
+					continue
+				}
+			}
+			if belongsToNamespace(name, others) {
+				changed = true
+				continue
+			}
+			if rule.CookieNamespace != "" && (name == proxyPathCookie || h.isAuthCookie(name, authConfig)) {
+				changed = true
+				continue
+			}
+			shared = append(shared, cookiePair{name: name, pair: pair})
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// A namespaced cookie wins over a shared one with the same name.
+	ownNames := make(map[string]bool, len(own))
+	for _, cookie := range own {
+		ownNames[cookie.name] = true
+	}
+	parts := make([]string, 0, len(own)+len(shared))
+	for _, cookie := range own {
+		parts = append(parts, cookie.pair)
+	}
+	for _, cookie := range shared {
+		if !ownNames[cookie.name] {
+			parts = append(parts, cookie.pair)
+		}
+	}
+
+	if len(parts) == 0 {
+		header.Del("Cookie")
+		return
+	}
+	header.Set("Cookie", strings.Join(parts, "; "))
+}
+
+func belongsToNamespace(name string, namespaces []string) bool {
+	for _, namespace := range namespaces {
+		if _, ok := stripCookieNamespace(name, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	routeByPath    = "path"
+	routeByCookie  = "cookie"
+	routeByReferer = "referer"
+	routeByDefault = "default_route"
+)
+
+// routeDebugHeader names the rule and the strategy that selected it when
+// the debug header is enabled.
+const routeDebugHeader = "X-Proxy-Route"
+
+func validateFallbackConfig(cfg models.FallbackConfig) error {
+	seen := make(map[string]bool, len(cfg.Strategies))
+	for _, strategy := range cfg.Strategies {
+		switch strategy {
+		case routeByCookie, routeByReferer:
+		default:
+			return fmt.Errorf("unknown fallback strategy %q, use %q or %q", strategy, routeByCookie, routeByReferer)
+		}
+		if seen[strategy] {
+			return fmt.Errorf("duplicate fallback strategy %q", strategy)
+		}
+		seen[strategy] = true
+	}
+	return nil
+}
+
+// fallbackMatch picks a rule for a request whose path matched nothing. The
+// strategies run in the configured order and the first hit wins; in strict
+// mode every strategy that finds a rule must agree, otherwise nothing is
+// routed.
+func fallbackMatch(r *http.Request, rules []models.Rule, cfg models.FallbackConfig) (*models.Rule, string) {
+	var found *models.Rule
+	var foundBy string
+	for _, strategy := range cfg.Strategies {
+		var rule *models.Rule
+		switch strategy {
+		case routeByCookie:
+			rule = matchByCookie(r, rules)
+		case routeByReferer:
+			rule = matchByReferer(r, rules)
+		}
+		if rule == nil {
+			continue
+		}
+		if !cfg.Strict {
+			return rule, strategy
+		}
+		if found == nil {
+			found, foundBy = rule, strategy
+			continue
+		}
+		if ruleMatchKey(*found) != ruleMatchKey(*rule) {
+			return nil, ""
+		}
+	}
+	return found, foundBy
+}
+
+func matchByCookie(r *http.Request, rules []models.Rule) *models.Rule {
+	isWebSocket := strings.ToLower(r.Header.Get("Upgrade")) == "websocket"
+	canUseCookie := r.URL.Path == "/" || r.Header.Get("Referer") != "" || r.Header.Get("Origin") != "" || isWebSocket
+	if !canUseCookie {
+		return nil
+	}
+	cookie, err := r.Cookie(proxyPathCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	for _, rule := range rules {
+		if cookie.Value == rule.Path && !rule.NoFallback && matchRulePredicates(rule, r) {
+			return copyRule(rule)
+		}
+	}
+	return nil
+}
+
+func matchByReferer(r *http.Request, rules []models.Rule) *models.Rule {
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		return nil
+	}
+	refURL, err := url.Parse(referer)
+	if err != nil {
+		return nil
+	}
+
+	var matched *models.Rule
+	var longest int
+	for _, rule := range rules {
+		if rule.NoFallback || !matchRulePredicates(rule, r) {
+			continue
+		}
+		if n, ok := matchRulePath(rule, refURL.Path); ok && betterMatch(rule, n, matched, longest) {
+			matched = copyRule(rule)
+			longest = n
+		}
+	}
+	return matched
+}
+
+func setRouteDebugHeader(w http.ResponseWriter, rule *models.Rule, routedBy string) {
+	if rule == nil {
+		w.Header().Set(routeDebugHeader, "none")
+		return
+	}
+	w.Header().Set(routeDebugHeader, routedBy+"; rule="+rule.Path)
+}
+
+func (h *Handler) GetFallbackConfig() models.FallbackConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Fallback
+}
+
+func (h *Handler) SetFallbackConfig(cfg models.FallbackConfig) error {
+	if cfg.Strategies == nil {
+		cfg.Strategies = []string{}
+	}
+	if err := validateFallbackConfig(cfg); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Fallback = cfg
+	h.saveConfigLocked()
+	return nil
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func validateFilesRoot(root string) error {
+	if root == "" {
+		return fmt.Errorf("files rule requires root")
+	}
+	if !filepath.IsAbs(root) {
+		return fmt.Errorf("root must be an absolute path")
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("invalid root: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root is not a directory: %s", root)
+	}
+	return nil
+}
+
+// serveFilesRule serves rule.Root under rule.Path. Dotfiles are never
+// served, directories fall back to index.html and, if enabled, to a listing.
+func (h *Handler) serveFilesRule(w http.ResponseWriter, r *http.Request, rule models.Rule, rules []models.Rule) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		response.HTML(w, http.StatusMethodNotAllowed, "Method Not Allowed", rules)
+		return
+	}
+
+	name := path.Clean(ensureLeadingSlash(strings.TrimPrefix(r.URL.Path, rule.Path)))
+	if hasDotSegment(name) {
+		response.HTML(w, errors.CodeNotFound, "Not Found", rules)
+		return
+	}
+
+	dir := http.Dir(rule.Root)
+	info, err := statFile(dir, name)
+	if err != nil {
+		if rule.SPAFallback && wantsPage(r, name) {
+			if info, err := statFile(dir, "/index.html"); err == nil {
+				w.Header().Set("Cache-Control", "no-cache")
+				serveFile(w, r, dir, "/index.html", info)
+				return
+			}
+		}
+		response.HTML(w, errors.CodeNotFound, "Not Found", rules)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		index := path.Join(name, "index.html")
+		if indexInfo, err := statFile(dir, index); err == nil {
+			serveFile(w, r, dir, index, indexInfo)
+			return
+		}
+		if rule.DirectoryListing {
+			serveDirectoryListing(w, r, dir, name)
+			return
+		}
+		response.HTML(w, errors.CodeNotFound, "Not Found", rules)
+		return
+	}
+
+	serveFile(w, r, dir, name, info)
+}
+
+func statFile(dir http.Dir, name string) (fs.FileInfo, error) {
+	f, err := dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func hasDotSegment(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") && seg != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsPage reports whether a missing path looks like a client-side route
+// rather than a missing asset.
+func wantsPage(r *http.Request, name string) bool {
+	if path.Ext(name) == "" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+var precompressedVariants = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, dir http.Dir, name string, info fs.FileInfo) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	for _, variant := range precompressedVariants {
+		if !acceptsEncoding(r, variant.encoding) {
+			continue
+		}
+		f, err := dir.Open(name + variant.ext)
+		if err != nil {
+			continue
+		}
+		vinfo, err := f.Stat()
+		if err != nil || vinfo.IsDir() {
+			f.Close()
+			continue
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", variant.encoding)
+		w.Header().Set("ETag", fileETag(vinfo))
+		http.ServeContent(w, r, name, vinfo.ModTime(), f)
+		f.Close()
+		return
+	}
+
+	f, err := dir.Open(name)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fileETag(info))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func serveDirectoryListing(w http.ResponseWriter, r *http.Request, dir http.Dir, name string) {
+	f, err := dir.Open(name)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]response.ListingEntry, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		entries = append(entries, response.ListingEntry{
+			Name:    info.Name(),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	response.DirectoryListing(w, r, r.URL.Path, entries)
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/geoip"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultGeoIPReloadInterval = 60 * time.Second
+
+func normalizeCountryCodes(codes []string) ([]string, error) {
+	var out []string
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %q, use ISO 3166-1 alpha-2 codes such as \"DE\"", code)
+		}
+		if !slices.Contains(out, code) {
+			out = append(out, code)
+		}
+	}
+	return out, nil
+}
+
+func normalizeGeoIPConfig(cfg *models.GeoIPConfig) error {
+	cfg.CountryDB = strings.TrimSpace(cfg.CountryDB)
+	cfg.ASNDB = strings.TrimSpace(cfg.ASNDB)
+	for _, path := range []string{cfg.CountryDB, cfg.ASNDB} {
+		if path != "" && !filepath.IsAbs(path) {
+			return fmt.Errorf("database path %q must be absolute", path)
+		}
+	}
+	if cfg.ReloadInterval < 0 {
+		return fmt.Errorf("reload_interval must not be negative")
+	}
+	countries, err := normalizeCountryCodes(cfg.IptablesBlockCountries)
+	if err != nil {
+		return fmt.Errorf("iptables_block_countries: %v", err)
+	}
+	cfg.IptablesBlockCountries = countries
+	return nil
+}
+
+func normalizeGeoFilter(filter *models.GeoFilter) error {
+	allow, err := normalizeCountryCodes(filter.AllowCountries)
+	if err != nil {
+		return fmt.Errorf("allow_countries: %v", err)
+	}
+	deny, err := normalizeCountryCodes(filter.DenyCountries)
+	if err != nil {
+		return fmt.Errorf("deny_countries: %v", err)
+	}
+	for _, asn := range append(slices.Clone(filter.AllowASNs), filter.DenyASNs...) {
+		if asn == 0 {
+			return fmt.Errorf("invalid ASN 0")
+		}
+	}
+	action := strings.ToLower(strings.TrimSpace(filter.Action))
+	switch action {
+	case "":
+		action = ipFilterForbid
+	case ipFilterForbid, ipFilterDrop:
+	default:
+		return fmt.Errorf("invalid action %q, use %q or %q", filter.Action, ipFilterForbid, ipFilterDrop)
+	}
+	filter.AllowCountries, filter.DenyCountries, filter.Action = allow, deny, action
+	return nil
+}
+
+func validateRuleGeoFilter(rule *models.Rule) error {
+	if rule.GeoFilter == nil {
+		return nil
+	}
+	if err := normalizeGeoFilter(rule.GeoFilter); err != nil {
+		return fmt.Errorf("invalid geo_filter: %v", err)
+	}
+	f := rule.GeoFilter
+	if len(f.AllowCountries) == 0 && len(f.DenyCountries) == 0 && len(f.AllowASNs) == 0 && len(f.DenyASNs) == 0 {
+		rule.GeoFilter = nil
+	}
+	return nil
+}
+
+// geoFilterAllows reports whether a client passes the filter. Clients the
+// databases do not know only pass allow lists with AllowUnknown.
+func geoFilterAllows(filter models.GeoFilter, geo geoip.Record) bool {
+	if geo.Country != "" && slices.Contains(filter.DenyCountries, geo.Country) {
+		return false
+	}
+	if geo.ASN != 0 && slices.Contains(filter.DenyASNs, geo.ASN) {
+		return false
+	}
+	if len(filter.AllowCountries) == 0 && len(filter.AllowASNs) == 0 {
+		return true
+	}
+	if geo.Country == "" && geo.ASN == 0 {
+		return filter.AllowUnknown
+	}
+	return (geo.Country != "" && slices.Contains(filter.AllowCountries, geo.Country)) ||
+		(geo.ASN != 0 && slices.Contains(filter.AllowASNs, geo.ASN))
+}
+
+func (h *Handler) checkGeoFilter(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, filter models.GeoFilter, geo geoip.Record) bool {
+	if geoFilterAllows(filter, geo) {
+		return true
+	}
+	atomic.AddUint64(&h.trafficGeoDenied, 1)
+	if filter.Action == ipFilterDrop {
+		h.abortConnection(w)
+		return false
+	}
+	location := "your location"
+	if geo.Country != "" {
+		location = geo.Country
+	}
+	response.ProxyError(w, r, errors.CodeProxyClientDenied, "Access from "+location+" is not allowed", snapshot.rules)
+	return false
+}
+
+func (h *Handler) lookupGeoIP(clientIP string) geoip.Record {
+	if !h.geoDB.Loaded() {
+		return geoip.Record{}
+	}
+	return h.geoDB.Lookup(net.ParseIP(clientIP))
+}
+
+func geoIPReloadInterval(cfg models.GeoIPConfig) time.Duration {
+	return secondsOr(cfg.ReloadInterval, defaultGeoIPReloadInterval)
+}
+
+func (h *Handler) configureGeoIP(cfg models.GeoIPConfig) error {
+	return h.geoDB.Configure([]string{cfg.CountryDB, cfg.ASNDB}, geoIPReloadInterval(cfg), func() {
+		if hook := h.getGeoIPChangeHook(); hook != nil {
+			hook()
+		}
+	})
+}
+
+func (h *Handler) SetGeoIPChangeHook(hook func()) {
+	h.geoIPOnChange.Store(hook)
+}
+
+func (h *Handler) getGeoIPChangeHook() func() {
+	val := h.geoIPOnChange.Load()
+	if val == nil {
+		return nil
+	}
+	hook, _ := val.(func())
+	return hook
+}
+
+func (h *Handler) GetGeoIP() models.GeoIPConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	cfg := h.GeoIP
+	cfg.IptablesBlockCountries = append([]string{}, cfg.IptablesBlockCountries...)
+	return cfg
+}
+
+// SetGeoIP checks that the databases can be read before switching to them.
+func (h *Handler) SetGeoIP(cfg models.GeoIPConfig) error {
+	if err := normalizeGeoIPConfig(&cfg); err != nil {
+		return err
+	}
+	for _, path := range []string{cfg.CountryDB, cfg.ASNDB} {
+		if path == "" {
+			continue
+		}
+		if err := geoip.Check(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if len(cfg.IptablesBlockCountries) > 0 && cfg.CountryDB == "" {
+		return fmt.Errorf("iptables_block_countries requires country_db")
+	}
+
+	if err := h.configureGeoIP(cfg); err != nil {
+		log.Printf("Failed to load GeoIP database %v", err)
+	}
+	h.mu.Lock()
+	h.GeoIP = cfg
+	h.saveConfigLocked()
+	h.mu.Unlock()
+
+	if hook := h.getGeoIPChangeHook(); hook != nil {
+		hook()
+	}
+	return nil
+}
+
+func (h *Handler) GetGeoIPStatus() []geoip.DatabaseStatus {
+	return h.geoDB.Status()
+}
+
+func (h *Handler) LookupGeoIP(ip string) (geoip.Record, error) {
+	parsed := net.ParseIP(normalizeClientIP(ip))
+	if parsed == nil {
+		return geoip.Record{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+	return h.geoDB.Lookup(parsed), nil
+}
+
+// GeoIPBlockedNetworks returns the networks of the countries configured for
+// iptables blocking.
+func (h *Handler) GeoIPBlockedNetworks() ([]string, error) {
+	countries := h.GetGeoIP().IptablesBlockCountries
+	if len(countries) == 0 {
+		return nil, nil
+	}
+	return h.geoDB.CountryNetworks(countries)
+}
@@ -2,7 +2,7 @@ package proxy
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"go-reauth-proxy/pkg/config"
 	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/geoip"
+	"go-reauth-proxy/pkg/middleware"
 
 	"go-reauth-proxy/pkg/models"
 	"go-reauth-proxy/pkg/response"
@@ -20,12 +22,15 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/pires/go-proxyproto"
 )
 
 type Handler struct {
@@ -35,9 +40,25 @@ type Handler struct {
 	AuthConfig            models.AuthConfig
 	AdminPort             int
 	ProxyProtocolForce    bool
+	TargetAllowlist       []string
+	Fallback              models.FallbackConfig
+	Listeners             []models.PortConfig
+	TrustedProxies        models.TrustedProxyConfig
+	RateLimit             models.RateLimit
+	ClientLimits          models.ClientLimits
+	IPFilter              models.IPFilter
+	GeoIP                 models.GeoIPConfig
 	sslCert               atomic.Value
 	sslOnChange           atomic.Value
 	proxyProtocolOnChange atomic.Value
+	listenersOnChange     atomic.Value
+	geoIPOnChange         atomic.Value
+	targetAllowlist       atomic.Value
+	trustedProxies        atomic.Value
+	adminBind             atomic.Value
+
+	proxyPort     int
+	listenerCerts map[int]*tls.Certificate
 
 	configManager *config.Manager
 	certPEM       string
@@ -48,7 +69,23 @@ type Handler struct {
 	trafficActive   int64
 	trafficError5xx uint64
 
-	loggedInActive sync.Map
+	trafficRateLimited     uint64
+	trafficOverloaded      uint64
+	trafficBodyTooLarge    uint64
+	trafficHeadersTooLarge uint64
+	trafficReadTimeouts    uint64
+	trafficConnsRejected   uint64
+	trafficIPDenied        uint64
+	trafficGeoDenied       uint64
+
+	loggedInActive  sync.Map
+	authCookieNames sync.Map
+
+	transports          *transportPool
+	rateLimiters        *rateLimiterPool
+	concurrencyLimiters *concurrencyLimiterPool
+	ipConns             ipConnCounter
+	geoDB               *geoip.DB
 }
 
 type requestSnapshot struct {
@@ -56,68 +93,43 @@ type requestSnapshot struct {
 	defaultRoute       string
 	authConfig         models.AuthConfig
 	proxyProtocolForce bool
+	fallback           models.FallbackConfig
+	trustedProxies     *trustedProxies
+	rateLimit          models.RateLimit
+	clientLimits       models.ClientLimits
+	ipFilter           models.IPFilter
 }
 
-func (h *Handler) snapshotForRequest() requestSnapshot {
+func (h *Handler) snapshotForRequest(r *http.Request) requestSnapshot {
 	h.mu.RLock()
-	rules := make([]models.Rule, len(h.Rules))
-	copy(rules, h.Rules)
+	rules, defaultRoute, proxyProtocolForce := h.Rules, h.DefaultRoute, h.ProxyProtocolForce
+	if port, ok := requestListenerPort(r); ok {
+		if listener := h.findListenerLocked(port); listener != nil {
+			rules, defaultRoute, proxyProtocolForce = listener.Rules, listener.DefaultRoute, listener.ProxyProtocolForce
+		}
+	}
+	rulesCopy := make([]models.Rule, len(rules))
+	copy(rulesCopy, rules)
 	s := requestSnapshot{
-		rules:              rules,
-		defaultRoute:       h.DefaultRoute,
+		rules:              rulesCopy,
+		defaultRoute:       defaultRoute,
 		authConfig:         h.AuthConfig,
-		proxyProtocolForce: h.ProxyProtocolForce,
+		proxyProtocolForce: proxyProtocolForce,
+		fallback:           h.Fallback,
+		trustedProxies:     h.getTrustedProxies(),
+		rateLimit:          h.RateLimit,
+		clientLimits:       h.ClientLimits,
+		ipFilter:           h.IPFilter,
 	}
 	h.mu.RUnlock()
 	return s
 }
 
-func resolveClientIP(r *http.Request, proxyProtocolForce bool) string {
-	if !proxyProtocolForce {
-		remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-		return remoteIP
-	}
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
-		}
-	}
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return remoteIP
-}
-
 func copyRule(rule models.Rule) *models.Rule {
 	r := rule
 	return &r
 }
 
-func newInternalTransport() *http.Transport {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.MaxIdleConns = 100
-	transport.MaxIdleConnsPerHost = 100
-	transport.IdleConnTimeout = 90 * time.Second
-	transport.ForceAttemptHTTP2 = true
-	return transport
-}
-
-func newProxyTransport() *http.Transport {
-	transport := newInternalTransport()
-	transport.DialContext = (&net.Dialer{
-		Timeout:   6 * time.Second,
-		KeepAlive: 30 * time.Second,
-	}).DialContext
-	transport.TLSHandshakeTimeout = 10 * time.Second
-	transport.ResponseHeaderTimeout = 10 * time.Second
-	return transport
-}
-
 func ensureLeadingSlash(p string) string {
 	if p == "" {
 		return "/"
@@ -132,7 +144,7 @@ func localServiceURL(port int, urlPath string) string {
 	return fmt.Sprintf("http://127.0.0.1:%d%s", port, ensureLeadingSlash(urlPath))
 }
 
-func (h *Handler) shouldDenyByPreflight(r *http.Request, authConfig models.AuthConfig, clientIP string, isMatch bool) bool {
+func (h *Handler) shouldDenyByPreflight(r *http.Request, authConfig models.AuthConfig, clientIP string, geo geoip.Record, isMatch bool) bool {
 	if authConfig.AuthPort <= 0 {
 		return false
 	}
@@ -153,6 +165,12 @@ func (h *Handler) shouldDenyByPreflight(r *http.Request, authConfig models.AuthC
 	preflightReq.Header.Set("X-Forwarded-For", clientIP)
 	preflightReq.Header.Set("X-Forwarded-Path", r.URL.RequestURI())
 	preflightReq.Header.Set("X-Match", strconv.FormatBool(isMatch))
+	if geo.Country != "" {
+		preflightReq.Header.Set("X-Client-Country", geo.Country)
+	}
+	if geo.ASN != 0 {
+		preflightReq.Header.Set("X-Client-ASN", strconv.FormatUint(uint64(geo.ASN), 10))
+	}
 
 	if cookie := r.Header.Get("Cookie"); cookie != "" {
 		preflightReq.Header.Set("Cookie", cookie)
@@ -161,12 +179,7 @@ func (h *Handler) shouldDenyByPreflight(r *http.Request, authConfig models.AuthC
 		preflightReq.Header.Set("Authorization", auth)
 	}
 
-	client := &http.Client{
-		Timeout:   5 * time.Second,
-		Transport: newInternalTransport(),
-	}
-
-	resp, err := client.Do(preflightReq)
+	resp, err := h.authClient(authConfig).Do(preflightReq)
 	if err != nil {
 		log.Printf("Preflight request failed: %v", err)
 		return false
@@ -188,19 +201,66 @@ func (h *Handler) abortConnection(w http.ResponseWriter) {
 
 func NewHandler(adminPort int, cfgManager *config.Manager, initialCfg *config.AppConfig) *Handler {
 	h := &Handler{
-		Rules:              initialCfg.Rules,
-		DefaultRoute:       initialCfg.DefaultRoute,
-		AuthConfig:         initialCfg.AuthConfig,
-		AdminPort:          adminPort,
-		ProxyProtocolForce: initialCfg.ProxyProtocolForce,
-		configManager:      cfgManager,
-		certPEM:            initialCfg.SSLCert,
-		keyPEM:             initialCfg.SSLKey,
+		Rules:               initialCfg.Rules,
+		DefaultRoute:        initialCfg.DefaultRoute,
+		AuthConfig:          initialCfg.AuthConfig,
+		AdminPort:           adminPort,
+		ProxyProtocolForce:  initialCfg.ProxyProtocolForce,
+		configManager:       cfgManager,
+		certPEM:             initialCfg.SSLCert,
+		keyPEM:              initialCfg.SSLKey,
+		TargetAllowlist:     initialCfg.TargetAllowlist,
+		Fallback:            initialCfg.Fallback,
+		TrustedProxies:      initialCfg.TrustedProxies,
+		RateLimit:           initialCfg.RateLimit,
+		ClientLimits:        initialCfg.ClientLimits,
+		IPFilter:            initialCfg.IPFilter,
+		GeoIP:               initialCfg.GeoIP,
+		transports:          newTransportPool(),
+		rateLimiters:        newRateLimiterPool(),
+		concurrencyLimiters: newConcurrencyLimiterPool(),
+		geoDB:               geoip.New(),
+	}
+	h.loadInitialListeners(initialCfg.Listeners)
+
+	allowlist, err := parseTargetAllowlist(h.TargetAllowlist)
+	if err != nil {
+		log.Printf("Failed to load target allowlist: %v", err)
+		allowlist = &targetAllowlist{}
+	}
+	h.targetAllowlist.Store(allowlist)
+
+	trusted, err := parseTrustedProxies(h.TrustedProxies)
+	if err != nil {
+		log.Printf("Failed to load trusted proxies: %v", err)
+		trusted = &trustedProxies{untrusted: proxyproto.IGNORE}
+	}
+	h.trustedProxies.Store(trusted)
+
+	if err := normalizeRateLimit(&h.RateLimit); err != nil {
+		log.Printf("Failed to load rate limit: %v", err)
+		h.RateLimit = models.RateLimit{}
+	}
+	if err := validateClientLimits(h.ClientLimits); err != nil {
+		log.Printf("Failed to load client limits: %v", err)
+		h.ClientLimits = models.ClientLimits{}
+	}
+	if err := normalizeIPFilter(&h.IPFilter); err != nil {
+		log.Printf("Failed to load IP filter: %v", err)
+		h.IPFilter = models.IPFilter{Action: ipFilterForbid}
+	}
+	if err := normalizeGeoIPConfig(&h.GeoIP); err != nil {
+		log.Printf("Failed to load GeoIP config: %v", err)
+		h.GeoIP = models.GeoIPConfig{}
+	}
+	if err := h.configureGeoIP(h.GeoIP); err != nil {
+		log.Printf("Failed to load GeoIP database %v", err)
 	}
 
 	var emptyHook func()
 	h.sslOnChange.Store(emptyHook)
 	h.proxyProtocolOnChange.Store(emptyHook)
+	h.listenersOnChange.Store(emptyHook)
 
 	if h.certPEM != "" && h.keyPEM != "" {
 		cert, err := tls.X509KeyPair([]byte(h.certPEM), []byte(h.keyPEM))
@@ -257,6 +317,14 @@ func (h *Handler) saveConfigLocked() {
 		conf.DefaultRoute = h.DefaultRoute
 		conf.AuthConfig = h.AuthConfig
 		conf.ProxyProtocolForce = h.ProxyProtocolForce
+		conf.TargetAllowlist = h.TargetAllowlist
+		conf.Fallback = h.Fallback
+		conf.Listeners = h.Listeners
+		conf.TrustedProxies = h.TrustedProxies
+		conf.RateLimit = h.RateLimit
+		conf.ClientLimits = h.ClientLimits
+		conf.IPFilter = h.IPFilter
+		conf.GeoIP = h.GeoIP
 		conf.SSLCert = h.certPEM
 		conf.SSLKey = h.keyPEM
 		return nil
@@ -327,11 +395,66 @@ func (h *Handler) ClearSSLCertificate() {
 }
 
 func (h *Handler) AddRule(newRule models.Rule) error {
+	if err := h.validateRule(&newRule); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Rules = upsertRule(h.Rules, newRule)
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
+	h.saveConfigLocked()
+	return nil
+}
+
+// SetRules replaces all rules. Every rule is validated before anything
+// changes, so limiters of rules whose match key and limits are unchanged keep
+// their buckets and queues.
+func (h *Handler) SetRules(newRules []models.Rule) error {
+	h.mu.RLock()
+	newRules = slices.Clone(newRules)
+	keepUpstreamTLSKeys(newRules, h.Rules)
+	h.mu.RUnlock()
+
+	rules := make([]models.Rule, 0, len(newRules))
+	for _, rule := range newRules {
+		if err := h.validateRule(&rule); err != nil {
+			return err
+		}
+		rules = upsertRule(rules, rule)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Rules = rules
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
+	h.saveConfigLocked()
+	return nil
+}
+
+func upsertRule(rules []models.Rule, newRule models.Rule) []models.Rule {
+	newKey := ruleMatchKey(newRule)
+	for i, rule := range rules {
+		if ruleMatchKey(rule) == newKey {
+			rules[i] = newRule
+			return rules
+		}
+	}
+	return append(rules, newRule)
+}
+
+// validateRule checks a rule before it is stored and normalizes its
+// predicates in place.
+func (h *Handler) validateRule(newRule *models.Rule) error {
 	if newRule.Path == "/" || newRule.Path == "" {
 		return fmt.Errorf("cannot add rule for root path '/' or empty path")
 	}
-	if newRule.Target == "" {
-		return fmt.Errorf("cannot add rule with empty target")
+	if err := validateRuleType(*newRule); err != nil {
+		return err
 	}
 	if strings.HasPrefix(newRule.Path, "/__") || strings.HasPrefix(newRule.Path, "__") {
 		return fmt.Errorf("cannot add rule for reserved path starting with '__'")
@@ -339,84 +462,114 @@ func (h *Handler) AddRule(newRule models.Rule) error {
 	if strings.HasSuffix(newRule.Path, "/") {
 		return fmt.Errorf("path cannot end with a slash '/'")
 	}
-	if err := h.checkSafeTarget(newRule.Target); err != nil {
-		return fmt.Errorf("invalid target: %v", err)
+	if err := validateRuleRewrite(*newRule); err != nil {
+		return err
 	}
-
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	updated := false
-	for i, rule := range h.Rules {
-		if rule.Path == newRule.Path {
-			h.Rules[i] = newRule
-			updated = true
-			break
-		}
+	if err := normalizeRulePredicates(newRule); err != nil {
+		return err
 	}
-	if !updated {
-		h.Rules = append(h.Rules, newRule)
+	if err := validateHeaderOps(newRule.RequestHeaders); err != nil {
+		return fmt.Errorf("invalid request_headers: %v", err)
+	}
+	if err := validateHeaderOps(newRule.ResponseHeaders); err != nil {
+		return fmt.Errorf("invalid response_headers: %v", err)
+	}
+	if err := validateRuleRateLimit(newRule); err != nil {
+		return err
+	}
+	if err := validateRuleIPFilter(newRule); err != nil {
+		return err
+	}
+	if err := validateRuleGeoFilter(newRule); err != nil {
+		return err
+	}
+	if err := validateRuleClientLimits(*newRule); err != nil {
+		return err
+	}
+	if err := validateRuleConcurrency(*newRule); err != nil {
+		return err
+	}
+	if isProxyRule(*newRule) {
+		if err := h.checkSafeTarget(newRule.Target); err != nil {
+			if isTargetForbidden(err) {
+				return err
+			}
+			return fmt.Errorf("invalid target: %v", err)
+		}
+		if err := validateUpstreamTLS(*newRule); err != nil {
+			return err
+		}
+		if err := validateRuleTimeouts(*newRule); err != nil {
+			return err
+		}
+		if err := validateCookieNamespace(newRule.CookieNamespace); err != nil {
+			return err
+		}
 	}
-	h.saveConfigLocked()
 	return nil
 }
 
 func (h *Handler) checkSafeTarget(target string) error {
+	if _, _, err := parseRuleTarget(target); err != nil {
+		return err
+	}
+	if socketPath, _, ok := parseUnixTarget(target); ok {
+		return checkUnixSocket(socketPath)
+	}
+
 	u, err := url.Parse(target)
 	if err != nil {
 		return err
 	}
 	hostname := u.Hostname()
-	port := u.Port()
+	port, _ := strconv.Atoi(u.Port())
 
-	isInternal := false
+	var ips []net.IP
 	if hostname == "localhost" {
-		isInternal = true
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
 	} else if ip := net.ParseIP(hostname); ip != nil {
-		if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
-			isInternal = true
-		}
+		ips = []net.IP{ip}
 	} else {
-		ips, err := net.LookupIP(hostname)
+		ips, err = net.LookupIP(hostname)
 		if err != nil {
 			return fmt.Errorf("failed to resolve target hostname: %v", err)
 		}
-
-		if len(ips) > 0 {
-			isInternal = true
-			for _, ip := range ips {
-				if !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() {
-					isInternal = false
-					break
-				}
-			}
-		}
-	}
-
-	if !isInternal {
-		return fmt.Errorf("target must be an internal network address, external address not allowed: %s", hostname)
 	}
 
-	if hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1" {
-		if port == strconv.Itoa(h.AdminPort) {
-			return fmt.Errorf("cannot target local admin port %d", h.AdminPort)
+	for _, ip := range ips {
+		if err := h.checkTargetAddress(hostname, ip, port); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (h *Handler) RemoveRule(path string) {
+// RemoveRule removes the rule with the same path and predicates as match,
+// leaving other rules on that path in place. It reports whether a rule was
+// removed.
+func (h *Handler) RemoveRule(match models.Rule) (bool, error) {
+	if err := normalizeRulePredicates(&match); err != nil {
+		return false, err
+	}
+	key := ruleMatchKey(match)
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	newRules := make([]models.Rule, 0, len(h.Rules))
 	for _, rule := range h.Rules {
-		if rule.Path != path {
+		if ruleMatchKey(rule) != key {
 			newRules = append(newRules, rule)
 		}
 	}
+	if len(newRules) == len(h.Rules) {
+		return false, nil
+	}
 	h.Rules = newRules
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
 	h.saveConfigLocked()
+	return true, nil
 }
 
 func (h *Handler) FlushRules() {
@@ -424,6 +577,8 @@ func (h *Handler) FlushRules() {
 	defer h.mu.Unlock()
 
 	h.Rules = make([]models.Rule, 0)
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
 	h.saveConfigLocked()
 }
 
@@ -431,9 +586,7 @@ func (h *Handler) GetRules() []models.Rule {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	rules := make([]models.Rule, len(h.Rules))
-	copy(rules, h.Rules)
-	return rules
+	return redactUpstreamTLSKeys(h.Rules)
 }
 
 func (h *Handler) GetDefaultRoute() string {
@@ -479,26 +632,52 @@ func (h *Handler) SetAuthConfig(config models.AuthConfig) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.AuthConfig = config
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
 	h.saveConfigLocked()
 	return nil
 }
 
 type TrafficStats struct {
-	TotalIn     uint64 `json:"total_in"`
-	TotalOut    uint64 `json:"total_out"`
-	ActiveConns int64  `json:"active_conns"`
-	Error5xx    uint64 `json:"error_5xx"`
+	TotalIn         uint64 `json:"total_in"`
+	TotalOut        uint64 `json:"total_out"`
+	ActiveConns     int64  `json:"active_conns"`
+	Error5xx        uint64 `json:"error_5xx"`
+	RateLimited     uint64 `json:"rate_limited"`
+	Queued          int64  `json:"queued"`
+	Overloaded      uint64 `json:"overloaded"`
+	BodyTooLarge    uint64 `json:"body_too_large"`
+	HeadersTooLarge uint64 `json:"headers_too_large"`
+	ReadTimeouts    uint64 `json:"read_timeouts"`
+	RejectedConns   uint64 `json:"rejected_conns"` // Connections closed by max_conns_per_ip
+	IPDenied        uint64 `json:"ip_denied"`      // Requests rejected by the global or a rule IP filter
+	GeoDenied       uint64 `json:"geo_denied"`     // Requests rejected by a rule GeoIP filter
 }
 
 func (h *Handler) GetTrafficStats(timestamp time.Time) TrafficStats {
 	return TrafficStats{
-		TotalIn:     atomic.LoadUint64(&h.trafficTotalIn),
-		TotalOut:    atomic.LoadUint64(&h.trafficTotalOut),
-		ActiveConns: h.activeLoggedInCount(timestamp),
-		Error5xx:    atomic.LoadUint64(&h.trafficError5xx),
+		TotalIn:         atomic.LoadUint64(&h.trafficTotalIn),
+		TotalOut:        atomic.LoadUint64(&h.trafficTotalOut),
+		ActiveConns:     h.activeLoggedInCount(timestamp),
+		Error5xx:        atomic.LoadUint64(&h.trafficError5xx),
+		RateLimited:     atomic.LoadUint64(&h.trafficRateLimited),
+		Queued:          h.concurrencyLimiters.queued(),
+		Overloaded:      atomic.LoadUint64(&h.trafficOverloaded),
+		BodyTooLarge:    atomic.LoadUint64(&h.trafficBodyTooLarge),
+		HeadersTooLarge: atomic.LoadUint64(&h.trafficHeadersTooLarge),
+		ReadTimeouts:    atomic.LoadUint64(&h.trafficReadTimeouts),
+		RejectedConns:   atomic.LoadUint64(&h.trafficConnsRejected),
+		IPDenied:        atomic.LoadUint64(&h.trafficIPDenied),
+		GeoDenied:       atomic.LoadUint64(&h.trafficGeoDenied),
 	}
 }
 
+// ActiveRequests returns the number of requests being served, including
+// upgraded connections such as WebSockets.
+func (h *Handler) ActiveRequests() int64 {
+	return atomic.LoadInt64(&h.trafficActive)
+}
+
 const loggedInActiveWindow = 2 * time.Minute
 
 func canonicalCookieIdentity(r *http.Request) string {
@@ -646,6 +825,10 @@ func (tw *trafficResponseWriter) Flush() {
 	}
 }
 
+func (tw *trafficResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
 func (tw *trafficResponseWriter) Push(target string, opts *http.PushOptions) error {
 	ps, ok := tw.ResponseWriter.(http.Pusher)
 	if !ok {
@@ -672,35 +855,56 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	snapshot := h.snapshotForRequest()
+	snapshot := h.snapshotForRequest(r)
 	cleanedPath := path.Clean(r.URL.Path)
 	if strings.HasSuffix(r.URL.Path, "/") && cleanedPath != "/" {
 		cleanedPath += "/"
 	}
 	r.URL.Path = cleanedPath
 
+	clientIP := resolveClientIP(r, snapshot.trustedProxies, snapshot.proxyProtocolForce)
+	if !h.checkIPFilter(w, r, snapshot, snapshot.ipFilter, clientIP) {
+		return
+	}
+	geo := h.lookupGeoIP(clientIP)
+	if geo.Country != "" {
+		middleware.SetLogCountry(w, geo.Country)
+	}
+
 	if response.IsFaviconPath(r.URL.Path) {
 		response.ServeFavicon(w, r)
 		return
 	}
-
-	clientIP := resolveClientIP(r, snapshot.proxyProtocolForce)
+	if r.URL.Path == response.ShimPath {
+		response.ServeShim(w, r)
+		return
+	}
 
 	isSelectRoute := r.URL.Path == "/__select__"
 	isAuthRoute := strings.HasPrefix(r.URL.Path, "/__auth__/")
 
-	matchedRule, needsSlashRedirect := matchRule(r, snapshot.rules)
+	matchedRule, needsSlashRedirect, routedBy := matchRule(r, snapshot.rules, snapshot.fallback)
 
 	if matchedRule == nil && snapshot.defaultRoute != "" && snapshot.defaultRoute != "/__select__" {
 		for _, rule := range snapshot.rules {
 			if rule.Path == snapshot.defaultRoute {
 				matchedRule = copyRule(rule)
+				routedBy = routeByDefault
 				break
 			}
 		}
 	}
+	if snapshot.fallback.DebugHeader {
+		setRouteDebugHeader(w, matchedRule, routedBy)
+	}
+	if matchedRule != nil && matchedRule.IPFilter != nil && !h.checkIPFilter(w, r, snapshot, *matchedRule.IPFilter, clientIP) {
+		return
+	}
+	if matchedRule != nil && matchedRule.GeoFilter != nil && !h.checkGeoFilter(w, r, snapshot, *matchedRule.GeoFilter, geo) {
+		return
+	}
 	isMatch := isSelectRoute || isAuthRoute || matchedRule != nil || r.URL.Path == "/"
-	if h.shouldDenyByPreflight(r, snapshot.authConfig, clientIP, isMatch) {
+	if h.shouldDenyByPreflight(r, snapshot.authConfig, clientIP, geo, isMatch) {
 		h.abortConnection(w)
 		return
 	}
@@ -712,15 +916,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, newPath, http.StatusMovedPermanently)
 		return
 	}
+	r, ok := h.enforceRequestLimits(w, r, snapshot, matchedRule)
+	if !ok {
+		return
+	}
+	if (isSelectRoute || isAuthRoute || matchedRule == nil) && !h.checkRateLimit(w, r, snapshot, nil, clientIP, "", rateLimitAll) {
+		return
+	}
 	if isSelectRoute {
 		h.handleSelectRoute(w, r, snapshot, clientIP)
 		return
 	}
 	if isAuthRoute {
+		startBodyReadDeadline(w, r)
 		h.handleAuthProxyRoute(w, r, snapshot, clientIP)
 		return
 	}
 	if matchedRule == nil {
+		startBodyReadDeadline(w, r)
 		h.handleNoMatchRoute(w, r, snapshot, clientIP)
 		return
 	}
@@ -733,12 +946,32 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+	var user string
+	phase := rateLimitAll
 	if matchedRule.UseAuth && snapshot.authConfig.AuthURL != "" {
-		if !h.checkAuth(w, r, snapshot.authConfig, clientIP) {
+		if !h.checkRateLimit(w, r, snapshot, matchedRule, clientIP, "", rateLimitBeforeAuth) {
+			return
+		}
+		authUser, ok := h.checkAuth(w, r, snapshot.authConfig, clientIP)
+		if !ok {
 			return
 		}
+		user = authUser
+		phase = rateLimitAfterAuth
+	}
+	if !h.checkRateLimit(w, r, snapshot, matchedRule, clientIP, user, phase) {
+		return
+	}
+	release, ok := h.acquireRuleSlot(w, r, snapshot, *matchedRule)
+	if !ok {
+		return
+	}
+	defer release()
+	startBodyReadDeadline(w, r)
+	if h.serveLocalRule(w, r, snapshot, *matchedRule, clientIP, user) {
+		return
 	}
-	h.proxyToRuleTarget(w, r, snapshot, *matchedRule, clientIP)
+	h.proxyToRuleTarget(w, r, snapshot, *matchedRule, clientIP, user)
 }
 
 func (h *Handler) handleSelectRoute(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, clientIP string) bool {
@@ -746,7 +979,7 @@ func (h *Handler) handleSelectRoute(w http.ResponseWriter, r *http.Request, snap
 		return false
 	}
 	if snapshot.authConfig.AuthURL != "" {
-		if !h.checkAuth(w, r, snapshot.authConfig, clientIP) {
+		if _, ok := h.checkAuth(w, r, snapshot.authConfig, clientIP); !ok {
 			return true
 		}
 	}
@@ -785,7 +1018,11 @@ func (h *Handler) handleAuthProxyRoute(w http.ResponseWriter, r *http.Request, s
 	targetURL.Path = singleJoiningSlash(targetURL.Path, proxyPath)
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.Transport = newProxyTransport()
+	proxy.Transport = h.authTransport(snapshot.authConfig)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		h.learnAuthCookies(resp)
+		return nil
+	}
 
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
@@ -804,15 +1041,18 @@ func (h *Handler) handleAuthProxyRoute(w http.ResponseWriter, r *http.Request, s
 	return true
 }
 
-func matchRule(r *http.Request, rules []models.Rule) (*models.Rule, string) {
+func matchRule(r *http.Request, rules []models.Rule, fallback models.FallbackConfig) (*models.Rule, string, string) {
 	var matchedRule *models.Rule
 	var longestMatch int
 	var needsSlashRedirect string
 
 	for _, rule := range rules {
-		if strings.HasPrefix(r.URL.Path, rule.Path) && len(rule.Path) > longestMatch {
+		if !matchRulePredicates(rule, r) {
+			continue
+		}
+		if n, ok := matchRulePath(rule, r.URL.Path); ok && betterMatch(rule, n, matchedRule, longestMatch) {
 			matchedRule = copyRule(rule)
-			longestMatch = len(rule.Path)
+			longestMatch = n
 		}
 		if r.URL.Path+"/" == rule.Path {
 			needsSlashRedirect = rule.Path
@@ -830,38 +1070,14 @@ func matchRule(r *http.Request, rules []models.Rule) (*models.Rule, string) {
 		matchedRule = nil
 	}
 
-	if matchedRule == nil && needsSlashRedirect == "" {
-		isWebSocket := strings.ToLower(r.Header.Get("Upgrade")) == "websocket"
-		canUseCookie := r.URL.Path == "/" || r.Header.Get("Referer") != "" || r.Header.Get("Origin") != "" || isWebSocket
-		if canUseCookie {
-			if cookie, err := r.Cookie("__proxy_path"); err == nil && cookie.Value != "" {
-				for _, rule := range rules {
-					if cookie.Value == rule.Path {
-						matchedRule = copyRule(rule)
-						break
-					}
-				}
-			}
-		}
-
-		if matchedRule == nil {
-			referer := r.Header.Get("Referer")
-			if referer != "" {
-				refURL, err := url.Parse(referer)
-				if err == nil {
-					var longestRefMatch int
-					for _, rule := range rules {
-						if strings.HasPrefix(refURL.Path, rule.Path) && len(rule.Path) > longestRefMatch {
-							matchedRule = copyRule(rule)
-							longestRefMatch = len(rule.Path)
-						}
-					}
-				}
-			}
-		}
+	routedBy := ""
+	if matchedRule != nil {
+		routedBy = routeByPath
+	} else if needsSlashRedirect == "" {
+		matchedRule, routedBy = fallbackMatch(r, rules, fallback)
 	}
 
-	return matchedRule, needsSlashRedirect
+	return matchedRule, needsSlashRedirect, routedBy
 }
 
 func (h *Handler) handleNoMatchRoute(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, clientIP string) {
@@ -876,53 +1092,51 @@ func (h *Handler) handleNoMatchRoute(w http.ResponseWriter, r *http.Request, sna
 	response.HTML(w, errors.CodeNotFound, "Not Found", snapshot.rules)
 }
 
-func (h *Handler) proxyToRuleTarget(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, matchedRule models.Rule, clientIP string) {
-	targetURL, err := url.Parse(matchedRule.Target)
+func (h *Handler) proxyToRuleTarget(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, matchedRule models.Rule, clientIP string, user string) {
+	targetURL, socketPath, err := parseRuleTarget(matchedRule.Target)
 	if err != nil {
 		response.HTML(w, errors.CodeProxyTargetInvalid, "Invalid target URL configuration", snapshot.rules)
 		return
 	}
+	upstreamHost := targetURL.Host
+	if socketPath != "" {
+		upstreamHost = r.Host
+	}
+	rewriter := newPathRewriter(matchedRule, targetURL.Path)
+	headerTmpl := newHeaderTemplate(r, matchedRule, clientIP, user)
 
-	switch targetURL.Scheme {
-	case "ws":
-		targetURL.Scheme = "http"
-	case "wss":
-		targetURL.Scheme = "https"
+	if matchedRule.Timeout > 0 && !isUpgradeRequest(r) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(matchedRule.Timeout)*time.Second)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
 
-	transport := newProxyTransport()
 	proxy := &httputil.ReverseProxy{
-		Transport: transport,
+		Transport:     h.ruleTransport(matchedRule),
+		FlushInterval: ruleFlushInterval(matchedRule),
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetXForwarded()
 			pr.Out.Header.Set("X-Forwarded-For", clientIP)
 			pr.Out.Header.Set("X-Real-IP", clientIP)
 			pr.SetURL(targetURL)
-			pr.Out.Host = targetURL.Host
+			pr.Out.Host = upstreamHost
 
-			if matchedRule.StripPath {
-				pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, matchedRule.Path)
-				if !strings.HasPrefix(pr.Out.URL.Path, "/") {
-					pr.Out.URL.Path = "/" + pr.Out.URL.Path
-				}
+			if rewriter.active() {
+				pr.Out.URL.Path = rewriter.toUpstream(pr.In.URL.Path)
 				pr.Out.URL.RawPath = ""
 			}
 
 			if origin := pr.In.Header.Get("Origin"); origin != "" {
-				pr.Out.Header.Set("Origin", targetURL.Scheme+"://"+targetURL.Host)
+				pr.Out.Header.Set("Origin", targetURL.Scheme+"://"+upstreamHost)
 			}
 			if referer := pr.In.Header.Get("Referer"); referer != "" {
 				ref, err := url.Parse(referer)
 				if err == nil {
 					ref.Scheme = targetURL.Scheme
-					ref.Host = targetURL.Host
+					ref.Host = upstreamHost
 					ref.Path = path.Clean(ref.Path)
-
-					if matchedRule.StripPath {
-						ref.Path = strings.TrimPrefix(ref.Path, matchedRule.Path)
-						if !strings.HasPrefix(ref.Path, "/") {
-							ref.Path = "/" + ref.Path
-						}
+					if rewriter.active() {
+						ref.Path = rewriter.toUpstream(ref.Path)
 					}
 					ref.RawPath = ""
 
@@ -930,98 +1144,76 @@ func (h *Handler) proxyToRuleTarget(w http.ResponseWriter, r *http.Request, snap
 				}
 			}
 
-			if matchedRule.RewriteHTML || matchedRule.UseAuth {
-				pr.Out.Header.Del("Accept-Encoding")
+			if matchedRule.RewriteHTML || matchedRule.UseAuth || matchedRule.InjectShim {
+				restrictAcceptEncoding(pr.Out.Header)
+			}
+
+			h.filterUpstreamCookies(pr.Out.Header, matchedRule, snapshot.rules, snapshot.authConfig)
+
+			if host := applyHeaderOps(pr.Out.Header, matchedRule.RequestHeaders, headerTmpl); host != "" {
+				pr.Out.Host = host
 			}
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			log.Printf("Proxy error: %v", err)
-			response.HTML(w, errors.CodeProxyTimeout, "Upstream unavailable: "+err.Error(), h.GetRules())
+			if h.handleClientBodyError(w, r, snapshot) {
+				return
+			}
+			if isTargetForbidden(err) {
+				response.HTML(w, errors.CodeProxyTargetForbidden, "Upstream address not allowed", snapshot.rules)
+				return
+			}
+			response.HTML(w, errors.CodeProxyTimeout, "Upstream unavailable: "+err.Error(), snapshot.rules)
 		},
 	}
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		needsRewrite := matchedRule.RewriteHTML && !matchedRule.UseRootMode
+		needsToolbar := matchedRule.UseAuth
+
+		mapPaths := needsRewrite || matchedRule.RewriteRegex != "" || matchedRule.UpstreamPrefix != ""
+		urls := newUpstreamURLRewriter(r, rewriter, mapPaths, targetURL, upstreamHost)
+		urls.cookieNamespace = matchedRule.CookieNamespace
+		urls.rewriteHeaders(resp.Header)
+
 		cookie := &http.Cookie{
-			Name:  "__proxy_path",
+			Name:  proxyPathCookie,
 			Value: matchedRule.Path,
 			Path:  "/",
 		}
 		resp.Header.Add("Set-Cookie", cookie.String())
+		applyHeaderOps(resp.Header, matchedRule.ResponseHeaders, headerTmpl)
 
-		needsRewrite := matchedRule.RewriteHTML && !matchedRule.UseRootMode
-		needsToolbar := matchedRule.UseAuth
-		if !needsRewrite && !needsToolbar {
+		if !isHTMLResponse(resp) {
 			return nil
 		}
 
+		var opts htmlRewriteOptions
 		if needsRewrite {
-			if location := resp.Header.Get("Location"); location != "" {
-				if strings.HasPrefix(location, "/") {
-					resp.Header.Set("Location", matchedRule.Path+location)
-				}
-			}
-		}
-
-		contentType := resp.Header.Get("Content-Type")
-		if !strings.Contains(strings.ToLower(contentType), "text/html") {
-			return nil
-		}
-
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
+			opts.prefix = strings.TrimSuffix(matchedRule.Path, "/")
 		}
-		resp.Body.Close()
-
-		bodyStr := string(bodyBytes)
-
-		if needsRewrite {
-			prefix := strings.TrimSuffix(matchedRule.Path, "/")
-			replacements := []struct {
-				old string
-				new string
-			}{
-				{`href="/`, `href="` + prefix + `/`},
-				{`src="/`, `src="` + prefix + `/`},
-				{`action="/`, `action="` + prefix + `/`},
-				{`<base href="/">`, `<base href="` + prefix + `/">`},
-			}
-
-			for _, rep := range replacements {
-				bodyStr = strings.ReplaceAll(bodyStr, rep.old, rep.new)
-			}
+		if matchedRule.InjectShim && !matchedRule.UseRootMode {
+			opts.head = response.ShimTag(strings.TrimSuffix(matchedRule.Path, "/"))
 		}
-
 		if needsToolbar {
-			toolbarHTML := response.GenerateToolbar(snapshot.rules, matchedRule.Path)
-			lowerBody := strings.ToLower(bodyStr)
-			if idx := strings.LastIndex(lowerBody, "</body>"); idx != -1 {
-				bodyStr = bodyStr[:idx] + toolbarHTML + bodyStr[idx:]
-			} else if strings.Contains(lowerBody, "<html") || strings.Contains(lowerBody, "<head") || strings.Contains(lowerBody, "<body") || strings.Contains(lowerBody, "<!doctype") {
-				bodyStr += toolbarHTML
-			}
+			opts.toolbar = response.GenerateToolbar(snapshot.rules, matchedRule.Path)
+		}
+		if opts.enabled() {
+			rewriteHTMLResponse(resp, opts)
 		}
-
-		newBody := []byte(bodyStr)
-		resp.Body = io.NopCloser(bytes.NewReader(newBody))
-		resp.ContentLength = int64(len(newBody))
-		resp.Header.Set("Content-Length", strconv.Itoa(len(newBody)))
 		return nil
 	}
 
 	proxy.ServeHTTP(w, r)
 }
 
-func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request, authConfig models.AuthConfig, clientIP string) bool {
-	client := &http.Client{
-		Timeout:   5 * time.Second,
-		Transport: newInternalTransport(),
-	}
-
+// checkAuth verifies the request against the auth service and returns the
+// authenticated user reported by it, if any.
+func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request, authConfig models.AuthConfig, clientIP string) (string, bool) {
 	if authConfig.AuthPort <= 0 {
 		log.Printf("Auth check requested but AuthPort is not configured")
 		response.HTML(w, errors.CodeInternal, "Authentication Service Not Configured", nil)
-		return false
+		return "", false
 	}
 
 	authURLPath := authConfig.AuthURL
@@ -1034,7 +1226,7 @@ func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request, authConfig m
 	if err != nil {
 		log.Printf("Failed to create auth request: %v", err)
 		response.HTML(w, errors.CodeInternal, "Internal Server Error during Auth", nil)
-		return false
+		return "", false
 	}
 
 	authReq.Header.Set("X-Real-IP", clientIP)
@@ -1049,34 +1241,40 @@ func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request, authConfig m
 
 	authReq.Header.Set("X-Forwarded-Path", r.URL.RequestURI())
 
-	resp, err := client.Do(authReq)
+	resp, err := h.authClient(authConfig).Do(authReq)
 	if err != nil {
 		log.Printf("Auth request failed: %v", err)
 		response.HTML(w, errors.CodeProxyAuthFailed, "Authentication Service Unavailable", nil)
-		return false
+		return "", false
 	}
-	defer resp.Body.Close()
+	defer func() {
+		// Drain so the pooled connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 
 	var authResponse struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
+		User    string `json:"user"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&authResponse); err != nil {
 		log.Printf("Failed to decode auth response: %v", err)
 		response.HTML(w, errors.CodeInternal, "Invalid Auth Response Format", nil)
-		return false
+		return "", false
 	}
 	if authResponse.Success {
 		h.markLoggedInActive(r, clientIP, time.Now())
-		return true
+		user := authResponse.User
+		if user == "" {
+			user = resp.Header.Get("X-Auth-User")
+		}
+		return user, true
 	}
 	log.Printf("Auth failed: %s", authResponse.Message)
 
-	scheme := "http"
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	}
+	scheme := requestScheme(r)
 	host := r.Host
 	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
 		host = forwardedHost
@@ -1095,7 +1293,7 @@ func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request, authConfig m
 	loginURL.RawQuery = q.Encode()
 
 	http.Redirect(w, r, loginURL.String(), http.StatusFound)
-	return false
+	return "", false
 }
 
 func singleJoiningSlash(a, b string) string {
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
+func newHeaderTemplate(r *http.Request, rule models.Rule, clientIP, user string) *strings.Replacer {
+	return strings.NewReplacer(
+		"{client_ip}", clientIP,
+		"{rule_path}", rule.Path,
+		"{user}", user,
+		"{host}", r.Host,
+		"{scheme}", requestScheme(r),
+	)
+}
+
+// applyHeaderOps applies ops to header. A "Host" entry in Set is returned
+// separately because net/http takes the outgoing host from Request.Host.
+func applyHeaderOps(header http.Header, ops *models.HeaderOps, tmpl *strings.Replacer) (host string) {
+	if ops == nil {
+		return ""
+	}
+	for _, name := range ops.Remove {
+		header.Del(name)
+	}
+	for name, value := range ops.Set {
+		value = tmpl.Replace(value)
+		if textproto.CanonicalMIMEHeaderKey(name) == "Host" {
+			host = value
+			continue
+		}
+		header.Set(name, value)
+	}
+	for name, value := range ops.Add {
+		header.Add(name, tmpl.Replace(value))
+	}
+	return host
+}
+
+func validateHeaderOps(ops *models.HeaderOps) error {
+	if ops == nil {
+		return nil
+	}
+	names := make([]string, 0, len(ops.Set)+len(ops.Add)+len(ops.Remove))
+	for name := range ops.Set {
+		names = append(names, name)
+	}
+	for name := range ops.Add {
+		names = append(names, name)
+	}
+	names = append(names, ops.Remove...)
+
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
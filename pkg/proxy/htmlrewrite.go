@@ -0,0 +1,416 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// maxHTMLTokenSize bounds the memory used for a single token. A larger token
+// (e.g. a huge inline script) stops rewriting and the rest of the document is
+// copied through unchanged.
+const maxHTMLTokenSize = 1 << 20
+
+type htmlRewriteOptions struct {
+	prefix  string // rewrite root-relative URLs under this prefix, empty disables
+	head    string // injected at the start of <head>, empty disables
+	toolbar string // injected before </body>, empty disables
+}
+
+func (o htmlRewriteOptions) enabled() bool {
+	return o.prefix != "" || o.head != "" || o.toolbar != ""
+}
+
+var htmlURLAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"data":       true,
+	"background": true,
+	"manifest":   true,
+	"xlink:href": true,
+}
+
+var cssURLPattern = regexp.MustCompile(`(url\(\s*(?:["']|&quot;|&#34;|&#39;)?|@import\s+["'])(/[^/])`)
+
+func isHTMLResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	return strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/html")
+}
+
+// restrictAcceptEncoding keeps only the encodings the HTML rewriter can
+// decode, so the upstream may still compress the response.
+func restrictAcceptEncoding(header http.Header) {
+	var kept []string
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "br", "identity":
+			kept = append(kept, strings.TrimSpace(part))
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Accept-Encoding")
+		return
+	}
+	header.Set("Accept-Encoding", strings.Join(kept, ", "))
+}
+
+// rewriteHTMLResponse replaces resp.Body with a streaming rewrite of the
+// document. Responses in an encoding it cannot decode are left untouched.
+func rewriteHTMLResponse(resp *http.Response, opts htmlRewriteOptions) {
+	contentEncoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch contentEncoding {
+	case "", "identity", "gzip", "br":
+	default:
+		return
+	}
+
+	src := resp.Body
+	contentType := resp.Header.Get("Content-Type")
+	pr, pw := io.Pipe()
+	go func() {
+		err := streamHTMLRewrite(pw, src, contentEncoding, contentType, opts)
+		src.Close()
+		pw.CloseWithError(err)
+	}()
+
+	resp.Body = &htmlRewriteBody{PipeReader: pr, src: src}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+}
+
+type htmlRewriteBody struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+func (b *htmlRewriteBody) Close() error {
+	// Closing the upstream body unblocks the rewriter if the client went away.
+	b.src.Close()
+	return b.PipeReader.Close()
+}
+
+func streamHTMLRewrite(dst io.Writer, src io.Reader, contentEncoding, contentType string, opts htmlRewriteOptions) error {
+	var out io.WriteCloser = nopWriteCloser{dst}
+	switch contentEncoding {
+	case "gzip":
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		src = zr
+		out = gzip.NewWriter(dst)
+	case "br":
+		src = brotli.NewReader(src)
+		out = brotli.NewWriter(dst)
+	}
+
+	buffered := bufio.NewReaderSize(src, 4096)
+	peek, _ := buffered.Peek(1024)
+	enc, name := documentEncoding(peek, contentType)
+
+	var in io.Reader = buffered
+	var w io.Writer = out
+	var transcoder io.WriteCloser
+	if strings.HasPrefix(name, "utf-16") {
+		// The tokenizer only understands ASCII-compatible input.
+		in = transform.NewReader(buffered, enc.NewDecoder())
+		transcoder = transform.NewWriter(out, enc.NewEncoder())
+		w = transcoder
+		enc = nil
+	}
+	if enc != nil && opts.toolbar != "" {
+		if toolbar, err := encoding.HTMLEscapeUnsupported(enc.NewEncoder()).String(opts.toolbar); err == nil {
+			opts.toolbar = toolbar
+		}
+	}
+
+	bw := bufio.NewWriterSize(w, 32*1024)
+	err := rewriteHTMLTokens(bw, in, opts)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if transcoder != nil {
+		if cerr := transcoder.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// documentEncoding returns the document charset when it is known and not
+// UTF-8, or nil. An undeclared charset is treated as UTF-8.
+func documentEncoding(peek []byte, contentType string) (encoding.Encoding, string) {
+	enc, name, certain := charset.DetermineEncoding(peek, contentType)
+	if name == "utf-8" {
+		return nil, name
+	}
+	if !certain && name == "windows-1252" && !bytes.Contains(bytes.ToLower(peek), []byte("charset")) {
+		return nil, "utf-8"
+	}
+	return enc, name
+}
+
+func rewriteHTMLTokens(w *bufio.Writer, r io.Reader, opts htmlRewriteOptions) error {
+	z := xhtml.NewTokenizer(r)
+	z.SetMaxBuf(maxHTMLTokenSize)
+
+	var (
+		rawTextTag     string
+		sawDocument    bool
+		headWritten    = opts.head == ""
+		toolbarWritten = opts.toolbar == ""
+	)
+
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			switch z.Err() {
+			case io.EOF:
+				if !toolbarWritten && sawDocument {
+					_, _ = w.WriteString(opts.toolbar)
+				}
+				return nil
+			case xhtml.ErrBufferExceeded:
+				_, _ = w.Write(z.Raw())
+				_, _ = w.Write(z.Buffered())
+				_, err := io.Copy(w, r)
+				return err
+			}
+			return z.Err()
+		}
+
+		raw := z.Raw()
+		nextRawTextTag := ""
+		switch tt {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			raw = append([]byte(nil), raw...)
+			name, _ := z.TagName()
+			nextRawTextTag = string(name)
+			switch nextRawTextTag {
+			case "html", "head", "body":
+				sawDocument = true
+			}
+			if opts.prefix != "" {
+				raw = rewriteTagAttrs(raw, nextRawTextTag, opts.prefix)
+			}
+			if !headWritten {
+				// Scripts in <head> must run first; without a <head> the
+				// content goes before the first other element. Fragments
+				// are left alone.
+				switch nextRawTextTag {
+				case "html":
+				case "head":
+					raw = append(raw, opts.head...)
+					headWritten = true
+				default:
+					if sawDocument {
+						raw = append([]byte(opts.head), raw...)
+					}
+					headWritten = true
+				}
+			}
+		case xhtml.EndTagToken:
+			raw = append([]byte(nil), raw...)
+			name, _ := z.TagName()
+			if !toolbarWritten && string(name) == "body" {
+				if _, err := w.WriteString(opts.toolbar); err != nil {
+					return err
+				}
+				toolbarWritten = true
+			}
+		case xhtml.TextToken:
+			if rawTextTag == "style" && opts.prefix != "" {
+				raw = rewriteCSSURLs(raw, opts.prefix)
+			}
+		case xhtml.DoctypeToken:
+			sawDocument = true
+		}
+		rawTextTag = nextRawTextTag
+
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+}
+
+func prefixRootPath(value, prefix string) string {
+	if strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//") {
+		return prefix + value
+	}
+	return value
+}
+
+func rewriteCSSURLs(css []byte, prefix string) []byte {
+	if !bytes.Contains(css, []byte("url(")) && !bytes.Contains(css, []byte("@import")) {
+		return css
+	}
+	return cssURLPattern.ReplaceAll(css, []byte("${1}"+strings.ReplaceAll(prefix, "$", "$$")+"${2}"))
+}
+
+func rewriteSrcset(value, prefix string) string {
+	candidates := strings.Split(value, ",")
+	for i, candidate := range candidates {
+		trimmed := strings.TrimLeft(candidate, " \t\n\r\f")
+		lead := candidate[:len(candidate)-len(trimmed)]
+		candidates[i] = lead + prefixRootPath(trimmed, prefix)
+	}
+	return strings.Join(candidates, ",")
+}
+
+// rewriteRefreshURL rewrites the URL of a refresh value such as
+// "5; url=/next", used by both <meta http-equiv="refresh"> and the Refresh
+// header.
+func rewriteRefreshURL(value string, rewrite func(string) string) string {
+	idx := strings.Index(strings.ToLower(value), "url=")
+	if idx == -1 {
+		return value
+	}
+	start := idx + len("url=")
+	rest := strings.TrimSpace(value[start:])
+	quote := ""
+	if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+		quote = rest[:1]
+		rest = strings.TrimSuffix(rest[1:], quote)
+		return value[:start] + quote + rewrite(rest) + quote
+	}
+	return value[:start] + rewrite(rest)
+}
+
+type rawAttr struct {
+	name       string
+	valueStart int
+	valueEnd   int
+}
+
+// scanRawAttrs locates attribute values inside a raw start tag so they can
+// be replaced without re-serialising the tag.
+func scanRawAttrs(tag []byte) []rawAttr {
+	var attrs []rawAttr
+	i := 1
+	for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' {
+		i++
+	}
+	for i < len(tag) {
+		for i < len(tag) && (isHTMLSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '>' {
+			break
+		}
+		nameStart := i
+		i++
+		for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' && tag[i] != '=' {
+			i++
+		}
+		name := strings.ToLower(string(tag[nameStart:i]))
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || tag[i] != '=' {
+			continue
+		}
+		i++
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		if quote := tag[i]; quote == '"' || quote == '\'' {
+			i++
+			start := i
+			for i < len(tag) && tag[i] != quote {
+				i++
+			}
+			attrs = append(attrs, rawAttr{name: name, valueStart: start, valueEnd: i})
+			i++
+			continue
+		}
+		start := i
+		for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '>' {
+			i++
+		}
+		attrs = append(attrs, rawAttr{name: name, valueStart: start, valueEnd: i})
+	}
+	return attrs
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func rewriteTagAttrs(tag []byte, tagName, prefix string) []byte {
+	attrs := scanRawAttrs(tag)
+	if len(attrs) == 0 {
+		return tag
+	}
+
+	isRefresh := false
+	if tagName == "meta" {
+		for _, attr := range attrs {
+			if attr.name == "http-equiv" && strings.EqualFold(string(tag[attr.valueStart:attr.valueEnd]), "refresh") {
+				isRefresh = true
+			}
+		}
+	}
+
+	escapedPrefix := html.EscapeString(prefix)
+	var out []byte
+	last := 0
+	for _, attr := range attrs {
+		value := string(tag[attr.valueStart:attr.valueEnd])
+		rewritten := value
+		switch {
+		case htmlURLAttrs[attr.name]:
+			rewritten = prefixRootPath(value, escapedPrefix)
+		case attr.name == "srcset" || attr.name == "imagesrcset":
+			rewritten = rewriteSrcset(value, escapedPrefix)
+		case attr.name == "style":
+			rewritten = string(rewriteCSSURLs([]byte(value), escapedPrefix))
+		case attr.name == "content" && isRefresh:
+			rewritten = rewriteRefreshURL(value, func(u string) string {
+				return prefixRootPath(u, escapedPrefix)
+			})
+		}
+		if rewritten == value {
+			continue
+		}
+		out = append(out, tag[last:attr.valueStart]...)
+		out = append(out, rewritten...)
+		last = attr.valueEnd
+	}
+	if out == nil {
+		return tag
+	}
+	return append(out, tag[last:]...)
+}
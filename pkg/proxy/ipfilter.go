@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ipFilterForbid = "forbid"
+	ipFilterDrop   = "drop"
+)
+
+var parsedIPNets sync.Map
+
+func cachedIPNet(entry string) (*net.IPNet, error) {
+	if cached, ok := parsedIPNets.Load(entry); ok {
+		return cached.(*net.IPNet), nil
+	}
+	ipNet, err := parseIPNet(entry)
+	if err != nil {
+		return nil, err
+	}
+	parsedIPNets.Store(entry, ipNet)
+	return ipNet, nil
+}
+
+func normalizeIPList(entries []string) ([]string, error) {
+	var out []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ipNet, err := parseIPNet(entry)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ipNet.String())
+	}
+	return out, nil
+}
+
+// normalizeIPFilter validates the entries and stores them as canonical
+// CIDRs, so requests only look them up.
+func normalizeIPFilter(filter *models.IPFilter) error {
+	allow, err := normalizeIPList(filter.Allow)
+	if err != nil {
+		return fmt.Errorf("allow: %v", err)
+	}
+	deny, err := normalizeIPList(filter.Deny)
+	if err != nil {
+		return fmt.Errorf("deny: %v", err)
+	}
+	action := strings.ToLower(strings.TrimSpace(filter.Action))
+	switch action {
+	case "":
+		action = ipFilterForbid
+	case ipFilterForbid, ipFilterDrop:
+	default:
+		return fmt.Errorf("invalid action %q, use %q or %q", filter.Action, ipFilterForbid, ipFilterDrop)
+	}
+	*filter = models.IPFilter{Allow: allow, Deny: deny, Action: action}
+	return nil
+}
+
+func validateRuleIPFilter(rule *models.Rule) error {
+	if rule.IPFilter == nil {
+		return nil
+	}
+	if err := normalizeIPFilter(rule.IPFilter); err != nil {
+		return fmt.Errorf("invalid ip_filter: %v", err)
+	}
+	if len(rule.IPFilter.Allow) == 0 && len(rule.IPFilter.Deny) == 0 {
+		rule.IPFilter = nil
+	}
+	return nil
+}
+
+func ipListContains(entries []string, ip net.IP) bool {
+	for _, entry := range entries {
+		ipNet, err := cachedIPNet(entry)
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilterAllows reports whether a client passes the filter. Clients whose
+// address cannot be parsed only pass filters without an allow list.
+func ipFilterAllows(filter models.IPFilter, clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return len(filter.Allow) == 0
+	}
+	if ipListContains(filter.Deny, ip) {
+		return false
+	}
+	return len(filter.Allow) == 0 || ipListContains(filter.Allow, ip)
+}
+
+// checkIPFilter answers a denied client with a 403 page or by closing the
+// connection, depending on the filter action.
+func (h *Handler) checkIPFilter(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, filter models.IPFilter, clientIP string) bool {
+	if ipFilterAllows(filter, clientIP) {
+		return true
+	}
+	atomic.AddUint64(&h.trafficIPDenied, 1)
+	if filter.Action == ipFilterDrop {
+		h.abortConnection(w)
+		return false
+	}
+	response.ProxyError(w, r, errors.CodeProxyClientDenied, "Access from "+clientIP+" is not allowed", snapshot.rules)
+	return false
+}
+
+func (h *Handler) GetIPFilter() models.IPFilter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	filter := h.IPFilter
+	filter.Allow = append([]string{}, filter.Allow...)
+	filter.Deny = append([]string{}, filter.Deny...)
+	return filter
+}
+
+func (h *Handler) SetIPFilter(filter models.IPFilter) error {
+	if err := normalizeIPFilter(&filter); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.IPFilter = filter
+	h.saveConfigLocked()
+	return nil
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type listenerPortKey struct{}
+
+// ListenerHandler serves requests accepted on an additional listener with
+// the rules, default route and PROXY protocol policy of that listener.
+func (h *Handler) ListenerHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), listenerPortKey{}, port)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestListenerPort(r *http.Request) (int, bool) {
+	port, ok := r.Context().Value(listenerPortKey{}).(int)
+	return port, ok
+}
+
+func (h *Handler) findListenerLocked(port int) *models.PortConfig {
+	for i := range h.Listeners {
+		if h.Listeners[i].Port == port {
+			return &h.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// SetProxyPort records the main proxy port so listeners cannot reuse it.
+func (h *Handler) SetProxyPort(port int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.proxyPort = port
+}
+
+func (h *Handler) SetListenersChangeHook(hook func()) {
+	h.listenersOnChange.Store(hook)
+}
+
+func (h *Handler) getListenersChangeHook() func() {
+	val := h.listenersOnChange.Load()
+	if val == nil {
+		return nil
+	}
+	hook, _ := val.(func())
+	return hook
+}
+
+// GetListeners returns the additional listeners. Private keys are omitted.
+func (h *Handler) GetListeners() []models.PortConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	listeners := make([]models.PortConfig, len(h.Listeners))
+	for i, listener := range h.Listeners {
+		listener.Rules = redactUpstreamTLSKeys(listener.Rules)
+		listener.SSLKey = ""
+		listeners[i] = listener
+	}
+	return listeners
+}
+
+// GetListenerPorts returns the ports of the additional listeners.
+func (h *Handler) GetListenerPorts() []int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ports := make([]int, len(h.Listeners))
+	for i, listener := range h.Listeners {
+		ports[i] = listener.Port
+	}
+	return ports
+}
+
+// GetListenerProxyProtocolForce returns the PROXY protocol policy of a port.
+// The main proxy port uses the global policy.
+func (h *Handler) GetListenerProxyProtocolForce(port int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if listener := h.findListenerLocked(port); listener != nil {
+		return listener.ProxyProtocolForce
+	}
+	return h.ProxyProtocolForce
+}
+
+// GetListenerSSLCertificate returns the certificate served on a port, falling
+// back to the global certificate.
+func (h *Handler) GetListenerSSLCertificate(port int) *tls.Certificate {
+	h.mu.RLock()
+	cert := h.listenerCerts[port]
+	h.mu.RUnlock()
+	if cert != nil {
+		return cert
+	}
+	return h.GetSSLCertificate()
+}
+
+// SetListeners replaces all additional listeners. Every rule is validated
+// like a rule of the main port.
+func (h *Handler) SetListeners(listeners []models.PortConfig) error {
+	h.mu.RLock()
+	adminPort, proxyPort := h.AdminPort, h.proxyPort
+	current := make(map[int]models.PortConfig, len(h.Listeners))
+	for _, listener := range h.Listeners {
+		current[listener.Port] = listener
+	}
+	h.mu.RUnlock()
+
+	normalized := make([]models.PortConfig, 0, len(listeners))
+	seen := make(map[int]bool, len(listeners))
+	for _, listener := range listeners {
+		if listener.Port <= 0 || listener.Port > 65535 {
+			return fmt.Errorf("invalid listener port %d", listener.Port)
+		}
+		if listener.Port == adminPort || listener.Port == proxyPort {
+			return fmt.Errorf("listener port %d is already used by the proxy or admin server", listener.Port)
+		}
+		if seen[listener.Port] {
+			return fmt.Errorf("duplicate listener port %d", listener.Port)
+		}
+		seen[listener.Port] = true
+
+		listener.Rules = slices.Clone(listener.Rules)
+		keepUpstreamTLSKeys(listener.Rules, current[listener.Port].Rules)
+		rules := make([]models.Rule, 0, len(listener.Rules))
+		for _, rule := range listener.Rules {
+			if err := h.validateRule(&rule); err != nil {
+				if isTargetForbidden(err) {
+					return err
+				}
+				return fmt.Errorf("listener %d: %v", listener.Port, err)
+			}
+			rules = upsertRule(rules, rule)
+		}
+		listener.Rules = rules
+
+		bind, err := NormalizeBindAddresses(listener.Bind)
+		if err != nil {
+			return fmt.Errorf("listener %d: %v", listener.Port, err)
+		}
+		listener.Bind = bind
+
+		if listener.DefaultRoute == "" {
+			listener.DefaultRoute = "/__select__"
+		}
+
+		if listener.SSLCert != "" && listener.SSLKey == "" {
+			if prev, ok := current[listener.Port]; ok && prev.SSLCert == listener.SSLCert {
+				listener.SSLKey = prev.SSLKey
+			}
+		}
+		if listener.SSLCert == "" && listener.SSLKey != "" {
+			return fmt.Errorf("listener %d: ssl_key requires ssl_cert", listener.Port)
+		}
+		normalized = append(normalized, listener)
+	}
+
+	certs, err := loadListenerCerts(normalized)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.Listeners = normalized
+	h.listenerCerts = certs
+	h.pruneTransportsLocked()
+	h.pruneLimitersLocked()
+	h.saveConfigLocked()
+	hook := h.getListenersChangeHook()
+	h.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	return nil
+}
+
+func loadListenerCerts(listeners []models.PortConfig) (map[int]*tls.Certificate, error) {
+	certs := make(map[int]*tls.Certificate)
+	for _, listener := range listeners {
+		if listener.SSLCert == "" {
+			continue
+		}
+		cert, err := tls.X509KeyPair([]byte(listener.SSLCert), []byte(listener.SSLKey))
+		if err != nil {
+			return nil, fmt.Errorf("listener %d: invalid certificate or key: %v", listener.Port, err)
+		}
+		certs[listener.Port] = &cert
+	}
+	return certs, nil
+}
+
+func (h *Handler) loadInitialListeners(listeners []models.PortConfig) {
+	h.Listeners = listeners
+	h.listenerCerts = make(map[int]*tls.Certificate)
+	for _, listener := range listeners {
+		certs, err := loadListenerCerts([]models.PortConfig{listener})
+		if err != nil {
+			log.Printf("Failed to load listener SSL cert: %v", err)
+			continue
+		}
+		for port, cert := range certs {
+			h.listenerCerts[port] = cert
+		}
+	}
+}
+
+// NormalizeBindAddresses validates listener bind addresses. Each entry must
+// be an IP literal, IPv6 addresses may be written in brackets.
+func NormalizeBindAddresses(addrs []string) ([]string, error) {
+	normalized := make([]string, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		trimmed := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		ip := net.ParseIP(trimmed)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind address %q, use an IP address such as 0.0.0.0 or ::", addr)
+		}
+		if ip.To4() != nil {
+			trimmed = ip.To4().String()
+		} else {
+			trimmed = ip.String()
+		}
+		if !seen[trimmed] {
+			seen[trimmed] = true
+			normalized = append(normalized, trimmed)
+		}
+	}
+	return normalized, nil
+}
+
+// GetListenerBind returns the bind addresses configured for a port, or nil
+// when the port uses the global proxy bind addresses.
+func (h *Handler) GetListenerBind(port int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if listener := h.findListenerLocked(port); listener != nil && len(listener.Bind) > 0 {
+		bind := make([]string, len(listener.Bind))
+		copy(bind, listener.Bind)
+		return bind
+	}
+	return nil
+}
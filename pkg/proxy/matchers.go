@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// matchRulePredicates checks the method, header, query and cookie conditions
+// of a rule. Path matching is handled separately by matchRulePath.
+func matchRulePredicates(rule models.Rule, r *http.Request) bool {
+	if len(rule.Methods) > 0 && !methodAllowed(rule.Methods, r.Method) {
+		return false
+	}
+	for name, want := range rule.MatchHeaders {
+		if !matchValues(r.Header.Values(name), want) {
+			return false
+		}
+	}
+	if len(rule.MatchQuery) > 0 {
+		query := r.URL.Query()
+		for name, want := range rule.MatchQuery {
+			if !matchValues(query[name], want) {
+				return false
+			}
+		}
+	}
+	for name, want := range rule.MatchCookies {
+		var values []string
+		for _, c := range r.Cookies() {
+			if c.Name == name {
+				values = append(values, c.Value)
+			}
+		}
+		if !matchValues(values, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func rulePredicateCount(rule models.Rule) int {
+	n := len(rule.MatchHeaders) + len(rule.MatchQuery) + len(rule.MatchCookies)
+	if len(rule.Methods) > 0 {
+		n++
+	}
+	return n
+}
+
+func methodAllowed(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+		if method == http.MethodHead && strings.EqualFold(m, http.MethodGet) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValues(values []string, want string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if want == "" || want == "*" {
+		return true
+	}
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// betterMatch reports whether a candidate rule should replace the current
+// best match: match length first, then priority, then number of predicates.
+func betterMatch(candidate models.Rule, candidateLen int, best *models.Rule, bestLen int) bool {
+	if best == nil {
+		return true
+	}
+	if candidateLen != bestLen {
+		return candidateLen > bestLen
+	}
+	if candidate.Priority != best.Priority {
+		return candidate.Priority > best.Priority
+	}
+	return rulePredicateCount(candidate) > rulePredicateCount(*best)
+}
+
+func normalizeRulePredicates(rule *models.Rule) error {
+	for i, m := range rule.Methods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if !validHeaderName(m) {
+			return fmt.Errorf("invalid method %q", rule.Methods[i])
+		}
+		rule.Methods[i] = m
+	}
+	slices.Sort(rule.Methods)
+	rule.Methods = slices.Compact(rule.Methods)
+	for name := range rule.MatchHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid match_headers name %q", name)
+		}
+	}
+	rule.MatchHeaders = canonicalHeaderKeys(rule.MatchHeaders)
+	for name := range rule.MatchCookies {
+		if name == "" {
+			return fmt.Errorf("match_cookies name cannot be empty")
+		}
+	}
+	for name := range rule.MatchQuery {
+		if name == "" {
+			return fmt.Errorf("match_query name cannot be empty")
+		}
+	}
+	return nil
+}
+
+func canonicalHeaderKeys(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		out[http.CanonicalHeaderKey(name)] = value
+	}
+	return out
+}
+
+// ruleMatchKey identifies a rule by everything that decides whether it
+// matches, so rules sharing a path but differing in predicates can coexist.
+// Methods and header names are compared regardless of order and case.
+func ruleMatchKey(rule models.Rule) string {
+	methods := make([]string, len(rule.Methods))
+	for i, m := range rule.Methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(m))
+	}
+	slices.Sort(methods)
+	methods = slices.Compact(methods)
+
+	key, _ := json.Marshal(struct {
+		Path         string
+		MatchType    string
+		MatchPattern string
+		Methods      []string
+		MatchHeaders map[string]string
+		MatchQuery   map[string]string
+		MatchCookies map[string]string
+	}{
+		rule.Path,
+		rule.MatchType,
+		rule.MatchPattern,
+		methods,
+		canonicalHeaderKeys(rule.MatchHeaders),
+		rule.MatchQuery,
+		rule.MatchCookies,
+	})
+	return string(key)
+}
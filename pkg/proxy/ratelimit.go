@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimitKeyIP       = "ip"
+	rateLimitKeyIdentity = "identity"
+	rateLimitKeyHeader   = "header:"
+
+	globalRateLimitID   = "global"
+	rateLimitSweepEvery = time.Minute
+
+	// rateLimitMaxBuckets bounds the buckets of one limiter. Clients beyond
+	// it share a single bucket until idle buckets are swept.
+	rateLimitMaxBuckets = 100000
+	rateLimitOverflow   = "overflow"
+)
+
+// normalizeRateLimit validates a limit and fills in the default burst and
+// key. A zero rate disables the limit.
+func normalizeRateLimit(limit *models.RateLimit) error {
+	if limit.Rate < 0 || math.IsNaN(limit.Rate) || math.IsInf(limit.Rate, 0) {
+		return fmt.Errorf("rate must be a positive number of requests per second")
+	}
+	if limit.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	if limit.Rate == 0 {
+		*limit = models.RateLimit{}
+		return nil
+	}
+	if limit.Burst == 0 {
+		limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+
+	key := strings.TrimSpace(limit.Key)
+	switch lower := strings.ToLower(key); {
+	case lower == "" || lower == rateLimitKeyIP:
+		limit.Key = rateLimitKeyIP
+	case lower == rateLimitKeyIdentity:
+		limit.Key = rateLimitKeyIdentity
+	case strings.HasPrefix(lower, rateLimitKeyHeader):
+		name := strings.TrimSpace(key[len(rateLimitKeyHeader):])
+		if name == "" || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("invalid rate limit header %q", name)
+		}
+		limit.Key = rateLimitKeyHeader + http.CanonicalHeaderKey(name)
+	default:
+		return fmt.Errorf("invalid rate limit key %q, use %q, %q or %q", key, rateLimitKeyIP, rateLimitKeyIdentity, rateLimitKeyHeader+"<name>")
+	}
+	return nil
+}
+
+func validateRuleRateLimit(rule *models.Rule) error {
+	if rule.RateLimit == nil {
+		return nil
+	}
+	if err := normalizeRateLimit(rule.RateLimit); err != nil {
+		return fmt.Errorf("invalid rate_limit: %v", err)
+	}
+	if rule.RateLimit.Rate == 0 {
+		rule.RateLimit = nil
+	}
+	return nil
+}
+
+// rateLimitClientKey returns the bucket key of a request. Header keys are
+// only read from requests forwarded by a trusted proxy, since a client could
+// otherwise pick a fresh value, and bucket, for every request.
+func rateLimitClientKey(r *http.Request, limit models.RateLimit, clientIP, user string, trustHeaders bool) string {
+	switch {
+	case limit.Key == rateLimitKeyIdentity:
+		if user != "" {
+			return "user:" + user
+		}
+	case strings.HasPrefix(limit.Key, rateLimitKeyHeader) && trustHeaders:
+		if value := r.Header.Get(limit.Key[len(rateLimitKeyHeader):]); value != "" {
+			return "header:" + value
+		}
+	}
+	return "ip:" + clientIP
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the buckets of one limit. Buckets that have refilled
+// completely are dropped, since a new bucket starts full anyway.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     models.RateLimit
+	port      int
+	path      string
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	allowed   uint64
+	rejected  uint64
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	return math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+}
+
+// allow takes a token for key. When the bucket is empty it returns how long
+// until the next token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, known := l.buckets[key]
+	if now.Sub(l.lastSweep) >= rateLimitSweepEvery || (!known && len(l.buckets) >= rateLimitMaxBuckets) {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= float64(l.limit.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	if _, known = l.buckets[key]; !known && len(l.buckets) >= rateLimitMaxBuckets {
+		key = rateLimitOverflow
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = l.refill(b, now)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		l.allowed++
+		return true, 0
+	}
+	l.rejected++
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+type RateLimitStats struct {
+	Scope          string  `json:"scope"`          // "global" or "rule"
+	Port           int     `json:"port,omitempty"` // Listener port of the rule, 0 for the main proxy port
+	Path           string  `json:"path,omitempty"`
+	Rate           float64 `json:"rate"`
+	Burst          int     `json:"burst"`
+	Key            string  `json:"key"`
+	Clients        int     `json:"clients"`         // Clients with a partially used bucket
+	LimitedClients int     `json:"limited_clients"` // Clients whose bucket is currently empty
+	Allowed        uint64  `json:"allowed"`
+	Rejected       uint64  `json:"rejected"`
+}
+
+func (l *rateLimiter) stats(id string, now time.Time) RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := RateLimitStats{
+		Scope:    "rule",
+		Port:     l.port,
+		Path:     l.path,
+		Rate:     l.limit.Rate,
+		Burst:    l.limit.Burst,
+		Key:      l.limit.Key,
+		Allowed:  l.allowed,
+		Rejected: l.rejected,
+	}
+	if id == globalRateLimitID {
+		s.Scope = "global"
+	}
+	for _, b := range l.buckets {
+		tokens := l.refill(b, now)
+		if tokens >= float64(l.limit.Burst) {
+			continue
+		}
+		s.Clients++
+		if tokens < 1 {
+			s.LimitedClients++
+		}
+	}
+	return s
+}
+
+// rateLimiterPool keeps one limiter per global or rule limit. A limiter is
+// replaced, and its buckets reset, when its settings change.
+type rateLimiterPool struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimiter
+}
+
+func newRateLimiterPool() *rateLimiterPool {
+	return &rateLimiterPool{entries: make(map[string]*rateLimiter)}
+}
+
+func (p *rateLimiterPool) get(id string, limit models.RateLimit, port int, path string) *rateLimiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.entries[id]; ok && l.limit == limit && l.path == path {
+		return l
+	}
+	l := &rateLimiter{
+		limit:     limit,
+		port:      port,
+		path:      path,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	p.entries[id] = l
+	return l
+}
+
+func (p *rateLimiterPool) retain(ids map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id := range p.entries {
+		if _, ok := ids[id]; !ok {
+			delete(p.entries, id)
+		}
+	}
+}
+
+func (p *rateLimiterPool) stats(now time.Time) []RateLimitStats {
+	p.mu.Lock()
+	limiters := make(map[string]*rateLimiter, len(p.entries))
+	for id, l := range p.entries {
+		limiters[id] = l
+	}
+	p.mu.Unlock()
+
+	out := make([]RateLimitStats, 0, len(limiters))
+	for id, l := range limiters {
+		out = append(out, l.stats(id, now))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope == "global"
+		}
+		if out[i].Port != out[j].Port {
+			return out[i].Port < out[j].Port
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// ruleLimiterID identifies the rate and concurrency limiters of a rule on a
+// listener port, 0 being the main proxy port.
+func ruleLimiterID(port int, rule models.Rule) string {
+	return "rule:" + strconv.Itoa(port) + ":" + ruleMatchKey(rule)
+}
+
+func (h *Handler) pruneLimitersLocked() {
+	ids := make(map[string]struct{})
+	if h.RateLimit.Rate > 0 {
+		ids[globalRateLimitID] = struct{}{}
+	}
+	for _, rule := range h.Rules {
+		if rule.RateLimit != nil || rule.MaxInFlight > 0 {
+			ids[ruleLimiterID(0, rule)] = struct{}{}
+		}
+	}
+	for _, listener := range h.Listeners {
+		for _, rule := range listener.Rules {
+			if rule.RateLimit != nil || rule.MaxInFlight > 0 {
+				ids[ruleLimiterID(listener.Port, rule)] = struct{}{}
+			}
+		}
+	}
+	h.rateLimiters.retain(ids)
+	h.concurrencyLimiters.retain(ids)
+}
+
+// checkRateLimit applies the global limit and then the limit of the matched
+// rule, if any, and answers 429 when a bucket is empty.
+// rateLimitPhase selects the limits checked at one point of a request.
+// Limits that do not depend on the user run before authentication, so
+// unauthenticated floods never reach the auth service unthrottled.
+type rateLimitPhase int
+
+const (
+	rateLimitAll rateLimitPhase = iota
+	rateLimitBeforeAuth
+	rateLimitAfterAuth
+)
+
+func (p rateLimitPhase) includes(limit models.RateLimit) bool {
+	switch p {
+	case rateLimitBeforeAuth:
+		return limit.Key != rateLimitKeyIdentity
+	case rateLimitAfterAuth:
+		return limit.Key == rateLimitKeyIdentity
+	}
+	return true
+}
+
+func (h *Handler) checkRateLimit(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, rule *models.Rule, clientIP, user string, phase rateLimitPhase) bool {
+	now := time.Now()
+	trustHeaders := snapshot.trustedProxies.trustsHeaders(net.ParseIP(normalizeClientIP(r.RemoteAddr)), snapshot.proxyProtocolForce)
+	if snapshot.rateLimit.Rate > 0 && phase.includes(snapshot.rateLimit) {
+		limiter := h.rateLimiters.get(globalRateLimitID, snapshot.rateLimit, 0, "")
+		if ok, wait := limiter.allow(rateLimitClientKey(r, snapshot.rateLimit, clientIP, user, trustHeaders), now); !ok {
+			h.rejectRateLimited(w, r, snapshot, wait)
+			return false
+		}
+	}
+	if rule != nil && rule.RateLimit != nil && phase.includes(*rule.RateLimit) {
+		port, _ := requestListenerPort(r)
+		limiter := h.rateLimiters.get(ruleLimiterID(port, *rule), *rule.RateLimit, port, rule.Path)
+		if ok, wait := limiter.allow(rateLimitClientKey(r, *rule.RateLimit, clientIP, user, trustHeaders), now); !ok {
+			h.rejectRateLimited(w, r, snapshot, wait)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) rejectRateLimited(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, wait time.Duration) {
+	atomic.AddUint64(&h.trafficRateLimited, 1)
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response.ProxyError(w, r, errors.CodeProxyRateLimited, fmt.Sprintf("Too many requests, retry in %d seconds", seconds), snapshot.rules)
+}
+
+func (h *Handler) GetRateLimit() models.RateLimit {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.RateLimit
+}
+
+func (h *Handler) SetRateLimit(limit models.RateLimit) error {
+	if err := normalizeRateLimit(&limit); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.RateLimit = limit
+	h.pruneLimitersLocked()
+	h.saveConfigLocked()
+	return nil
+}
+
+func (h *Handler) GetRateLimitStats() []RateLimitStats {
+	return h.rateLimiters.stats(time.Now())
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// upstreamURLRewriter maps URLs and cookies in upstream response headers to
+// the public scheme, host and path prefix of the rule.
+type upstreamURLRewriter struct {
+	paths         *pathRewriter
+	mapPaths      bool // whether upstream paths differ from public paths
+	upstreamHosts []string
+	publicScheme  string
+	publicHost    string
+
+	cookieNamespace string // prefix for Set-Cookie names, empty keeps them
+}
+
+func newUpstreamURLRewriter(r *http.Request, paths *pathRewriter, mapPaths bool, targetURL *url.URL, upstreamHost string) *upstreamURLRewriter {
+	return &upstreamURLRewriter{
+		paths:    paths,
+		mapPaths: mapPaths,
+		upstreamHosts: []string{
+			canonicalHost(targetURL.Scheme, targetURL.Host),
+			canonicalHost(targetURL.Scheme, upstreamHost),
+		},
+		publicScheme: requestScheme(r),
+		publicHost:   r.Host,
+	}
+}
+
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	switch scheme {
+	case "https", "wss":
+		return host + ":443"
+	default:
+		return host + ":80"
+	}
+}
+
+func (u *upstreamURLRewriter) isUpstreamHost(scheme, host string) bool {
+	host = canonicalHost(scheme, host)
+	for _, upstream := range u.upstreamHosts {
+		if host == upstream {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *upstreamURLRewriter) publicPath(p string) string {
+	if !u.mapPaths {
+		return p
+	}
+	return u.paths.toPublic(p)
+}
+
+// rewriteURL handles root-relative URLs and absolute URLs that point at the
+// upstream. Anything else is returned unchanged.
+func (u *upstreamURLRewriter) rewriteURL(value string) string {
+	if strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//") {
+		return u.publicPath(value)
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return value
+	}
+	scheme := parsed.Scheme
+	if scheme == "" {
+		scheme = u.publicScheme
+	}
+	if !u.isUpstreamHost(scheme, parsed.Host) {
+		return value
+	}
+
+	parsed.Host = u.publicHost
+	if parsed.Scheme != "" {
+		parsed.Scheme = u.publicScheme
+	}
+	parsed.Path = u.publicPath(ensureLeadingSlash(parsed.Path))
+	parsed.RawPath = ""
+	return parsed.String()
+}
+
+func (u *upstreamURLRewriter) rewriteHeaders(header http.Header) {
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := header.Get(name); value != "" {
+			header.Set(name, u.rewriteURL(value))
+		}
+	}
+	if value := header.Get("Refresh"); value != "" {
+		header.Set("Refresh", rewriteRefreshURL(value, u.rewriteURL))
+	}
+
+	cookies := header.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	rewritten := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		rewritten[i] = u.rewriteSetCookie(cookie)
+	}
+	header["Set-Cookie"] = rewritten
+}
+
+// rewriteSetCookie moves the cookie Path under the public prefix and drops a
+// Domain that names the upstream, so the cookie becomes a host-only cookie
+// of the public host. The name gets the rule's cookie namespace. Other
+// attributes are kept verbatim.
+func (u *upstreamURLRewriter) rewriteSetCookie(value string) string {
+	parts := strings.Split(value, ";")
+	// __Host- cookies are rejected unless Path is "/".
+	hostOnly := strings.HasPrefix(strings.TrimSpace(parts[0]), "__Host-")
+	if u.cookieNamespace != "" {
+		if name, val, ok := strings.Cut(parts[0], "="); ok {
+			parts[0] = namespaceCookieName(strings.TrimSpace(name), u.cookieNamespace) + "=" + val
+		}
+	}
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		name, attr, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "path":
+			attr = strings.TrimSpace(attr)
+			if u.mapPaths && !hostOnly && strings.HasPrefix(attr, "/") {
+				p := u.publicPath(attr)
+				if p != "/" {
+					p = strings.TrimSuffix(p, "/")
+				}
+				part = " Path=" + p
+			}
+		case "domain":
+			domain := strings.TrimPrefix(strings.TrimSpace(attr), ".")
+			if u.isUpstreamDomain(domain) {
+				continue
+			}
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, ";")
+}
+
+func (u *upstreamURLRewriter) isUpstreamDomain(domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, upstream := range u.upstreamHosts {
+		host, _, _ := net.SplitHostPort(upstream)
+		if host == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	matchTypePrefix = "prefix"
+	matchTypeRegex  = "regex"
+	matchTypeGlob   = "glob"
+)
+
+var compiledPatterns sync.Map
+
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if cached, ok := compiledPatterns.Load(expr); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(expr, re)
+	return re, nil
+}
+
+// globToRegex converts a path glob into an anchored regex. "*" and "?" stay
+// within one path segment, "**" spans segments.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+func ruleMatcher(rule models.Rule) (*regexp.Regexp, error) {
+	switch rule.MatchType {
+	case "", matchTypePrefix:
+		return nil, nil
+	case matchTypeRegex:
+		if rule.MatchPattern == "" {
+			return nil, fmt.Errorf("match_pattern is required for match_type %q", rule.MatchType)
+		}
+		return compilePattern(rule.MatchPattern)
+	case matchTypeGlob:
+		if rule.MatchPattern == "" {
+			return nil, fmt.Errorf("match_pattern is required for match_type %q", rule.MatchType)
+		}
+		return compilePattern(globToRegex(rule.MatchPattern))
+	default:
+		return nil, fmt.Errorf("unknown match_type %q", rule.MatchType)
+	}
+}
+
+// matchRulePath reports whether p is handled by rule and how specific the
+// match is. Prefix rules score their prefix length, regex and glob rules the
+// length of their literal prefix, so the most specific rule wins.
+func matchRulePath(rule models.Rule, p string) (int, bool) {
+	re, err := ruleMatcher(rule)
+	if err != nil {
+		return 0, false
+	}
+	if re == nil {
+		if strings.HasPrefix(p, rule.Path) {
+			return len(rule.Path), true
+		}
+		return 0, false
+	}
+	if !re.MatchString(p) {
+		return 0, false
+	}
+	prefix, _ := re.LiteralPrefix()
+	return len(prefix), true
+}
+
+func validateRuleRewrite(rule models.Rule) error {
+	if _, err := ruleMatcher(rule); err != nil {
+		return err
+	}
+	if rule.RewriteRegex != "" {
+		if _, err := compilePattern(rule.RewriteRegex); err != nil {
+			return fmt.Errorf("invalid rewrite_regex: %v", err)
+		}
+	} else if rule.RewriteTarget != "" {
+		return fmt.Errorf("rewrite_target requires rewrite_regex")
+	}
+	if rule.UpstreamPrefix != "" && !strings.HasPrefix(rule.UpstreamPrefix, "/") {
+		return fmt.Errorf("upstream_prefix must start with '/'")
+	}
+	return nil
+}
+
+// pathRewriter maps paths between the public side of a rule and its
+// upstream. toUpstream is applied to the request path and Referer, toPublic
+// to Location headers coming back.
+type pathRewriter struct {
+	rule     models.Rule
+	basePath string
+	rewrite  *regexp.Regexp
+
+	// Literal prefixes of RewriteRegex and RewriteTarget, used to reverse a
+	// regex rewrite for redirects.
+	publicPrefix   string
+	upstreamPrefix string
+}
+
+func newPathRewriter(rule models.Rule, basePath string) *pathRewriter {
+	pr := &pathRewriter{rule: rule}
+	if basePath != "/" {
+		pr.basePath = strings.TrimSuffix(basePath, "/")
+	}
+	if rule.RewriteRegex != "" {
+		if re, err := compilePattern(rule.RewriteRegex); err == nil {
+			pr.rewrite = re
+			pr.publicPrefix, _ = re.LiteralPrefix()
+			pr.upstreamPrefix = rule.RewriteTarget
+			if idx := strings.IndexByte(pr.upstreamPrefix, '$'); idx != -1 {
+				pr.upstreamPrefix = pr.upstreamPrefix[:idx]
+			}
+		}
+	}
+	return pr
+}
+
+// active reports whether the rule changes the upstream path at all.
+func (pr *pathRewriter) active() bool {
+	return pr.rule.StripPath || pr.rewrite != nil || pr.rule.UpstreamPrefix != ""
+}
+
+func (pr *pathRewriter) toUpstream(p string) string {
+	switch {
+	case pr.rewrite != nil && pr.rewrite.MatchString(p):
+		p = pr.rewrite.ReplaceAllString(p, pr.rule.RewriteTarget)
+	case pr.rule.StripPath:
+		p = strings.TrimPrefix(p, pr.rule.Path)
+	}
+	p = ensureLeadingSlash(p)
+	if pr.rule.UpstreamPrefix != "" {
+		p = singleJoiningSlash(strings.TrimSuffix(pr.rule.UpstreamPrefix, "/"), p)
+	}
+	if pr.basePath != "" {
+		p = singleJoiningSlash(pr.basePath, p)
+	}
+	return p
+}
+
+func (pr *pathRewriter) toPublic(p string) string {
+	p = trimPathPrefix(p, pr.basePath)
+	p = trimPathPrefix(p, strings.TrimSuffix(pr.rule.UpstreamPrefix, "/"))
+
+	if pr.rewrite != nil && pr.upstreamPrefix != "" && strings.HasPrefix(p, pr.upstreamPrefix) {
+		return pr.publicPrefix + strings.TrimPrefix(p, pr.upstreamPrefix)
+	}
+	if pr.rule.StripPath || pr.prefixesRulePath() {
+		return pr.rule.Path + p
+	}
+	return p
+}
+
+// prefixesRulePath reports whether root-relative URLs of a plain prefix rule
+// with rewrite_html get the rule path prepended, as they always have, even
+// though the upstream path is not stripped.
+func (pr *pathRewriter) prefixesRulePath() bool {
+	plainPrefix := pr.rule.MatchType == "" || pr.rule.MatchType == matchTypePrefix
+	return plainPrefix && pr.rule.RewriteHTML && !pr.rule.UseRootMode && pr.rewrite == nil && pr.rule.UpstreamPrefix == ""
+}
+
+func trimPathPrefix(p, prefix string) string {
+	if prefix == "" {
+		return p
+	}
+	if p == prefix || strings.HasPrefix(p, prefix+"/") || strings.HasPrefix(p, prefix+"?") {
+		return ensureLeadingSlash(strings.TrimPrefix(p, prefix))
+	}
+	return p
+}
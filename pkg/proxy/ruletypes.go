@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"go-reauth-proxy/pkg/response"
+	"net/http"
+	"strings"
+)
+
+const (
+	ruleTypeProxy       = "proxy"
+	ruleTypeRedirect    = "redirect"
+	ruleTypeStatic      = "static"
+	ruleTypeMaintenance = "maintenance"
+	ruleTypeFiles       = "files"
+)
+
+func isProxyRule(rule models.Rule) bool {
+	return rule.Type == "" || rule.Type == ruleTypeProxy
+}
+
+func validateRuleType(rule models.Rule) error {
+	switch rule.Type {
+	case "", ruleTypeProxy:
+		if rule.Target == "" {
+			return fmt.Errorf("cannot add rule with empty target")
+		}
+	case ruleTypeRedirect:
+		if rule.RedirectURL == "" {
+			return fmt.Errorf("redirect rule requires redirect_url")
+		}
+		switch rule.RedirectCode {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return fmt.Errorf("redirect_code must be one of 301, 302, 307 or 308")
+		}
+	case ruleTypeStatic, ruleTypeMaintenance:
+		if rule.StatusCode != 0 && (rule.StatusCode < 100 || rule.StatusCode > 599) {
+			return fmt.Errorf("invalid status_code %d", rule.StatusCode)
+		}
+	case ruleTypeFiles:
+		if err := validateFilesRoot(rule.Root); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", rule.Type)
+	}
+	return nil
+}
+
+// serveLocalRule answers rules that have no upstream. It returns false for
+// proxy rules.
+func (h *Handler) serveLocalRule(w http.ResponseWriter, r *http.Request, snapshot requestSnapshot, rule models.Rule, clientIP string, user string) bool {
+	if isProxyRule(rule) {
+		return false
+	}
+	applyHeaderOps(w.Header(), rule.ResponseHeaders, newHeaderTemplate(r, rule, clientIP, user))
+
+	switch rule.Type {
+	case ruleTypeRedirect:
+		code := rule.RedirectCode
+		if code == 0 {
+			code = http.StatusFound
+		}
+		http.Redirect(w, r, expandRedirectURL(r, rule, clientIP), code)
+	case ruleTypeStatic:
+		status := rule.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+		}
+		contentType := rule.ContentType
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write([]byte(rule.Body))
+		}
+	case ruleTypeMaintenance:
+		status := rule.StatusCode
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		message := rule.Body
+		if message == "" {
+			message = "This service is under maintenance, please try again later."
+		}
+		response.Maintenance(w, status, message)
+	case ruleTypeFiles:
+		h.serveFilesRule(w, r, rule, snapshot.rules)
+	}
+	return true
+}
+
+func expandRedirectURL(r *http.Request, rule models.Rule, clientIP string) string {
+	location := rule.RedirectURL
+
+	if rule.MatchType == matchTypeRegex {
+		if re, err := ruleMatcher(rule); err == nil && re != nil {
+			if m := re.FindStringSubmatchIndex(r.URL.Path); m != nil {
+				location = string(re.ExpandString(nil, location, r.URL.Path, m))
+			}
+		}
+	}
+
+	suffix := r.URL.Path
+	if strings.HasPrefix(suffix, rule.Path) {
+		suffix = ensureLeadingSlash(strings.TrimPrefix(suffix, rule.Path))
+	}
+
+	return strings.NewReplacer(
+		"{path}", r.URL.Path,
+		"{suffix}", suffix,
+		"{query}", r.URL.RawQuery,
+		"{request_uri}", r.URL.RequestURI(),
+		"{host}", r.Host,
+		"{scheme}", requestScheme(r),
+		"{rule_path}", rule.Path,
+		"{client_ip}", clientIP,
+	).Replace(location)
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const unixTargetPrefix = "unix://"
+
+// unixSocketHost is the URL authority used for requests sent over a unix
+// socket. The dialer ignores it; the client Host header is forwarded instead.
+const unixSocketHost = "localhost"
+
+// unixPathSeparator ends the socket path when an HTTP base path follows it.
+const unixPathSeparator = ".sock:/"
+
+// parseUnixTarget splits "unix:///run/app.sock" or "unix:///run/app.sock:/base"
+// into the socket path and the optional HTTP base path. The base path starts
+// at the first ":/" after a path component ending in ".sock", so other socket
+// paths may contain colons but cannot carry a base path.
+func parseUnixTarget(target string) (socketPath string, httpPath string, ok bool) {
+	rest, ok := strings.CutPrefix(target, unixTargetPrefix)
+	if !ok {
+		return "", "", false
+	}
+	if i := strings.Index(rest, unixPathSeparator); i >= 0 {
+		end := i + len(".sock")
+		return rest[:end], rest[end+1:], true
+	}
+	return rest, "", true
+}
+
+// parseRuleTarget returns the upstream URL of a proxy rule and, for unix
+// socket targets, the socket path to dial.
+func parseRuleTarget(target string) (*url.URL, string, error) {
+	if socketPath, httpPath, ok := parseUnixTarget(target); ok {
+		if socketPath == "" {
+			return nil, "", fmt.Errorf("unix target requires a socket path")
+		}
+		if httpPath != "" && !strings.HasPrefix(httpPath, "/") {
+			return nil, "", fmt.Errorf("unix target path must start with '/'")
+		}
+		return &url.URL{Scheme: "http", Host: unixSocketHost, Path: httpPath}, socketPath, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	return u, "", nil
+}
+
+func checkUnixSocket(socketPath string) error {
+	if !strings.HasPrefix(socketPath, "/") {
+		return fmt.Errorf("unix socket path must be absolute: %s", socketPath)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		return fmt.Errorf("unix socket not available: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("not a unix socket: %s", socketPath)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	stderrors "errors"
+	"fmt"
+	"go-reauth-proxy/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// targetAllowlist holds external hosts and networks that proxy rules may
+// target on purpose. Everything else must resolve to an internal address.
+type targetAllowlist struct {
+	hosts []string // exact names, or ".example.com" for "*.example.com"
+	nets  []*net.IPNet
+}
+
+func parseTargetAllowlist(entries []string) (*targetAllowlist, error) {
+	list := &targetAllowlist{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") || net.ParseIP(entry) != nil {
+			ipNet, err := parseIPNet(entry)
+			if err != nil {
+				return nil, err
+			}
+			list.nets = append(list.nets, ipNet)
+			continue
+		}
+		host := strings.TrimPrefix(entry, "*")
+		if strings.ContainsAny(host, "*:?# ") || strings.Trim(host, ".") == "" {
+			return nil, fmt.Errorf("invalid host %q", entry)
+		}
+		list.hosts = append(list.hosts, host)
+	}
+	return list, nil
+}
+
+// parseIPNet accepts a CIDR or a single IP address.
+func parseIPNet(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", entry)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (l *targetAllowlist) allowsHost(hostname string) bool {
+	if l == nil {
+		return false
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, host := range l.hosts {
+		if strings.HasPrefix(host, ".") {
+			if strings.HasSuffix(hostname, host) {
+				return true
+			}
+		} else if hostname == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *targetAllowlist) allowsIP(ip net.IP) bool {
+	if l == nil {
+		return false
+	}
+	for _, ipNet := range l.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast()
+}
+
+func targetForbidden(format string, args ...any) error {
+	return errors.New(errors.CodeProxyTargetForbidden, fmt.Sprintf(format, args...))
+}
+
+func isTargetForbidden(err error) bool {
+	var customErr *errors.CustomError
+	return stderrors.As(err, &customErr) && customErr.Code == errors.CodeProxyTargetForbidden
+}
+
+func (h *Handler) getTargetAllowlist() *targetAllowlist {
+	list, _ := h.targetAllowlist.Load().(*targetAllowlist)
+	return list
+}
+
+// checkTargetAddress applies the target policy to a resolved upstream
+// address. hostname is the name from the rule target and may be allowlisted
+// on its own.
+func (h *Handler) checkTargetAddress(hostname string, ip net.IP, port int) error {
+	if port == h.AdminPort && h.isAdminAddress(ip) {
+		return targetForbidden("cannot target local admin port %d", h.AdminPort)
+	}
+	if isInternalIP(ip) {
+		return nil
+	}
+	list := h.getTargetAllowlist()
+	if list.allowsIP(ip) || list.allowsHost(hostname) {
+		return nil
+	}
+	return targetForbidden("target %s resolves to external address %s, which is not in the target allowlist", hostname, ip)
+}
+
+// SetAdminBind records the addresses the admin server listens on, so rules
+// cannot reach it through them.
+func (h *Handler) SetAdminBind(hosts []string) {
+	ips := make([]net.IP, 0, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	h.adminBind.Store(ips)
+}
+
+// isAdminAddress reports whether a connection to ip on the admin port would
+// reach the admin server.
+func (h *Handler) isAdminAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	binds, _ := h.adminBind.Load().([]net.IP)
+	for _, bind := range binds {
+		if bind.Equal(ip) || (bind.IsUnspecified() && isLocalIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalIP reports whether ip is assigned to one of the host's interfaces.
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		// Assume the worst rather than expose the admin API.
+		return true
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetDialControl re-checks the policy on every connection, so a hostname
+// that starts resolving elsewhere after the rule was added is refused.
+func (h *Handler) targetDialControl(hostname string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return targetForbidden("cannot verify upstream address %s", address)
+		}
+		port, _ := strconv.Atoi(portStr)
+		return h.checkTargetAddress(hostname, ip, port)
+	}
+}
+
+func (h *Handler) GetTargetAllowlist() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	entries := make([]string, len(h.TargetAllowlist))
+	copy(entries, h.TargetAllowlist)
+	return entries
+}
+
+func (h *Handler) SetTargetAllowlist(entries []string) error {
+	list, err := parseTargetAllowlist(entries)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.TargetAllowlist = append([]string{}, entries...)
+	h.targetAllowlist.Store(list)
+	// Kept-alive connections were checked against the old list.
+	h.transports.closeIdle()
+	h.saveConfigLocked()
+	return nil
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDialTimeout           = 6 * time.Second
+	defaultResponseHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout           = 90 * time.Second
+)
+
+func validateRuleTimeouts(rule models.Rule) error {
+	if rule.DialTimeout < 0 {
+		return fmt.Errorf("dial_timeout must not be negative")
+	}
+	if rule.ResponseHeaderTimeout < -1 {
+		return fmt.Errorf("response_header_timeout must be -1, 0 or positive")
+	}
+	if rule.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout must not be negative")
+	}
+	if rule.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if rule.FlushInterval < -1 {
+		return fmt.Errorf("flush_interval must be -1, 0 or positive")
+	}
+	return nil
+}
+
+func secondsOr(v int, def time.Duration) time.Duration {
+	if v == 0 {
+		return def
+	}
+	if v < 0 {
+		return 0
+	}
+	return time.Duration(v) * time.Second
+}
+
+func ruleDialTimeout(rule models.Rule) time.Duration {
+	return secondsOr(rule.DialTimeout, defaultDialTimeout)
+}
+
+// applyRuleTimeouts sets the transport-level timeouts of a rule. The total
+// request timeout is applied per request in proxyToRuleTarget.
+func applyRuleTimeouts(transport *http.Transport, rule models.Rule) {
+	transport.ResponseHeaderTimeout = secondsOr(rule.ResponseHeaderTimeout, defaultResponseHeaderTimeout)
+	transport.IdleConnTimeout = secondsOr(rule.IdleTimeout, defaultIdleTimeout)
+}
+
+// ruleTimeoutsKey distinguishes transports whose timeouts differ from the
+// defaults. It is empty for rules that keep the defaults.
+func ruleTimeoutsKey(rule models.Rule) string {
+	if rule.DialTimeout == 0 && rule.ResponseHeaderTimeout == 0 && rule.IdleTimeout == 0 {
+		return ""
+	}
+	return fmt.Sprintf("d%d-h%d-i%d", rule.DialTimeout, rule.ResponseHeaderTimeout, rule.IdleTimeout)
+}
+
+func ruleFlushInterval(rule models.Rule) time.Duration {
+	if rule.FlushInterval < 0 {
+		return -1
+	}
+	return time.Duration(rule.FlushInterval) * time.Millisecond
+}
+
+func isUpgradeRequest(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") && r.Header.Get("Upgrade") != ""
+}
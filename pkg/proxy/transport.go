@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func newInternalTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ForceAttemptHTTP2 = true
+	return transport
+}
+
+func newProxyTransport() *http.Transport {
+	transport := newInternalTransport()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   6 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 10 * time.Second
+	return transport
+}
+
+// pooledTransport is a long-lived upstream transport shared by every request
+// that resolves to the same key. It counts dials, reused connections and
+// currently open connections so the pool can be inspected from the admin API.
+type pooledTransport struct {
+	key       string
+	transport *http.Transport
+	createdAt time.Time
+
+	requests  uint64
+	dials     uint64
+	reused    uint64
+	openConns int64
+}
+
+func newPooledTransport(key string, transport *http.Transport) *pooledTransport {
+	pt := &pooledTransport{
+		key:       key,
+		transport: transport,
+		createdAt: time.Now(),
+	}
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&pt.dials, 1)
+		atomic.AddInt64(&pt.openConns, 1)
+		return &countedConn{Conn: conn, pool: pt}, nil
+	}
+	return pt
+}
+
+func (pt *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddUint64(&pt.requests, 1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddUint64(&pt.reused, 1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return pt.transport.RoundTrip(req)
+}
+
+func (pt *pooledTransport) close() {
+	pt.transport.CloseIdleConnections()
+}
+
+func (pt *pooledTransport) stats() TransportStats {
+	return TransportStats{
+		Key:       pt.key,
+		Requests:  atomic.LoadUint64(&pt.requests),
+		Dials:     atomic.LoadUint64(&pt.dials),
+		Reused:    atomic.LoadUint64(&pt.reused),
+		OpenConns: atomic.LoadInt64(&pt.openConns),
+		CreatedAt: pt.createdAt.UnixMilli(),
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	pool *pooledTransport
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.pool.openConns, -1)
+	})
+	return c.Conn.Close()
+}
+
+type TransportStats struct {
+	Key       string `json:"key" example:"http://127.0.0.1:8080"`
+	Requests  uint64 `json:"requests"`
+	Dials     uint64 `json:"dials"`
+	Reused    uint64 `json:"reused"`
+	OpenConns int64  `json:"open_conns"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// transportPool keeps one transport per upstream key. Entries are created
+// lazily on first use and only dropped when the configuration no longer
+// references them.
+type transportPool struct {
+	mu      sync.Mutex
+	entries map[string]*pooledTransport
+}
+
+func newTransportPool() *transportPool {
+	return &transportPool{entries: make(map[string]*pooledTransport)}
+}
+
+func (p *transportPool) get(key string, build func() *http.Transport) *pooledTransport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pt, ok := p.entries[key]; ok {
+		return pt
+	}
+	pt := newPooledTransport(key, build())
+	p.entries[key] = pt
+	return pt
+}
+
+func (p *transportPool) retain(keys map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, pt := range p.entries {
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		pt.close()
+		delete(p.entries, key)
+	}
+}
+
+func (p *transportPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pt := range p.entries {
+		pt.close()
+	}
+}
+
+func (p *transportPool) stats() []TransportStats {
+	p.mu.Lock()
+	out := make([]TransportStats, 0, len(p.entries))
+	for _, pt := range p.entries {
+		out = append(out, pt.stats())
+	}
+	p.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func ruleTransportKey(rule models.Rule) string {
+	u, socketPath, err := parseRuleTarget(rule.Target)
+	if err != nil {
+		return "rule:" + rule.Target
+	}
+	if socketPath != "" {
+		key := "rule:" + unixTargetPrefix + socketPath
+		if tk := ruleTimeoutsKey(rule); tk != "" {
+			key += "#t-" + tk
+		}
+		return key
+	}
+	key := "rule:" + u.Scheme + "://" + u.Host
+	if fp := upstreamTLSFingerprint(rule.TLS); fp != "" {
+		key += "#tls-" + fp
+	}
+	if tk := ruleTimeoutsKey(rule); tk != "" {
+		key += "#t-" + tk
+	}
+	return key
+}
+
+func (h *Handler) newRuleTransport(rule models.Rule) func() *http.Transport {
+	return func() *http.Transport {
+		transport := newProxyTransport()
+		applyRuleTimeouts(transport, rule)
+		dialer := &net.Dialer{
+			Timeout:   ruleDialTimeout(rule),
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+		if socketPath, _, ok := parseUnixTarget(rule.Target); ok {
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			}
+		} else if u, _, err := parseRuleTarget(rule.Target); err == nil {
+			dialer.Control = h.targetDialControl(u.Hostname())
+		}
+		if rule.TLS != nil {
+			tlsConfig, err := buildUpstreamTLSConfig(rule.TLS)
+			if err != nil {
+				log.Printf("Invalid upstream TLS settings for %s: %v", rule.Path, err)
+			} else {
+				transport.TLSClientConfig = tlsConfig
+			}
+		}
+		return transport
+	}
+}
+
+func authTransportKey(authConfig models.AuthConfig) string {
+	return fmt.Sprintf("auth:127.0.0.1:%d", authConfig.AuthPort)
+}
+
+func (h *Handler) ruleTransport(rule models.Rule) *pooledTransport {
+	return h.transports.get(ruleTransportKey(rule), h.newRuleTransport(rule))
+}
+
+func (h *Handler) authTransport(authConfig models.AuthConfig) *pooledTransport {
+	return h.transports.get(authTransportKey(authConfig), newProxyTransport)
+}
+
+func (h *Handler) authClient(authConfig models.AuthConfig) *http.Client {
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: h.authTransport(authConfig),
+	}
+}
+
+func (h *Handler) pruneTransportsLocked() {
+	keys := make(map[string]struct{}, len(h.Rules)+1)
+	for _, rule := range h.Rules {
+		if isProxyRule(rule) {
+			keys[ruleTransportKey(rule)] = struct{}{}
+		}
+	}
+	for _, listener := range h.Listeners {
+		for _, rule := range listener.Rules {
+			if isProxyRule(rule) {
+				keys[ruleTransportKey(rule)] = struct{}{}
+			}
+		}
+	}
+	keys[authTransportKey(h.AuthConfig)] = struct{}{}
+	h.transports.retain(keys)
+}
+
+func (h *Handler) GetTransportStats() []TransportStats {
+	return h.transports.stats()
+}
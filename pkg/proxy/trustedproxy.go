@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+)
+
+const (
+	proxyProtocolUse     = "use"
+	proxyProtocolRequire = "require"
+	proxyProtocolIgnore  = "ignore"
+	proxyProtocolReject  = "reject"
+)
+
+type trustedNet struct {
+	net          *net.IPNet
+	policy       proxyproto.Policy
+	trustHeaders bool
+}
+
+// trustedProxies decides per source address whether PROXY protocol headers
+// and forwarded client IP headers are honored.
+type trustedProxies struct {
+	nets      []trustedNet // most specific first
+	untrusted proxyproto.Policy
+}
+
+func parseProxyProtocolPolicy(value string, trusted bool) (proxyproto.Policy, error) {
+	switch strings.ToLower(value) {
+	case proxyProtocolIgnore:
+		return proxyproto.IGNORE, nil
+	case proxyProtocolReject:
+		return proxyproto.REJECT, nil
+	}
+	if !trusted {
+		return 0, fmt.Errorf("invalid proxy_protocol %q for untrusted sources, use %q or %q", value, proxyProtocolIgnore, proxyProtocolReject)
+	}
+	switch strings.ToLower(value) {
+	case "", proxyProtocolUse:
+		return proxyproto.USE, nil
+	case proxyProtocolRequire:
+		return proxyproto.REQUIRE, nil
+	}
+	return 0, fmt.Errorf("invalid proxy_protocol %q, use %q, %q, %q or %q", value, proxyProtocolUse, proxyProtocolRequire, proxyProtocolIgnore, proxyProtocolReject)
+}
+
+func parseTrustedProxies(cfg models.TrustedProxyConfig) (*trustedProxies, error) {
+	untrusted := proxyproto.IGNORE
+	if cfg.ProxyProtocol != "" {
+		policy, err := parseProxyProtocolPolicy(cfg.ProxyProtocol, false)
+		if err != nil {
+			return nil, err
+		}
+		untrusted = policy
+	}
+
+	list := &trustedProxies{untrusted: untrusted}
+	for _, entry := range cfg.Proxies {
+		ipNet, err := parseIPNet(strings.TrimSpace(entry.CIDR))
+		if err != nil {
+			return nil, err
+		}
+		policy, err := parseProxyProtocolPolicy(entry.ProxyProtocol, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.CIDR, err)
+		}
+		list.nets = append(list.nets, trustedNet{net: ipNet, policy: policy, trustHeaders: entry.TrustHeaders})
+	}
+	sort.SliceStable(list.nets, func(i, j int) bool {
+		a, _ := list.nets[i].net.Mask.Size()
+		b, _ := list.nets[j].net.Mask.Size()
+		return a > b
+	})
+	return list, nil
+}
+
+// lookup returns the entry for a source address. Without an explicit entry,
+// loopback peers are trusted on ports with proxy_protocol_force, which only
+// bind loopback addresses.
+func (t *trustedProxies) lookup(ip net.IP, proxyProtocolForce bool) (trustedNet, bool) {
+	if t != nil {
+		for _, entry := range t.nets {
+			if entry.net.Contains(ip) {
+				return entry, true
+			}
+		}
+	}
+	if proxyProtocolForce && ip.IsLoopback() {
+		return trustedNet{policy: proxyproto.USE, trustHeaders: true}, true
+	}
+	return trustedNet{}, false
+}
+
+func (t *trustedProxies) trustsHeaders(ip net.IP, proxyProtocolForce bool) bool {
+	entry, ok := t.lookup(ip, proxyProtocolForce)
+	return ok && entry.trustHeaders
+}
+
+func (h *Handler) getTrustedProxies() *trustedProxies {
+	list, _ := h.trustedProxies.Load().(*trustedProxies)
+	return list
+}
+
+// ProxyProtocolPolicy returns the PROXY protocol policy for connections
+// accepted on a port. It is evaluated per connection, so changes apply
+// without rebinding.
+func (h *Handler) ProxyProtocolPolicy(port int) proxyproto.ConnPolicyFunc {
+	return func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+		list := h.getTrustedProxies()
+		untrusted := proxyproto.IGNORE
+		if list != nil {
+			untrusted = list.untrusted
+		}
+		addr, ok := opts.Upstream.(*net.TCPAddr)
+		if !ok {
+			return untrusted, nil
+		}
+		if entry, ok := list.lookup(addr.IP, h.GetListenerProxyProtocolForce(port)); ok {
+			return entry.policy, nil
+		}
+		return untrusted, nil
+	}
+}
+
+// resolveClientIP walks X-Forwarded-For from the right and stops at the
+// first address that is not a trusted proxy, so a client can only prepend
+// entries nobody reads. X-Real-IP is used when a trusted peer sends no
+// X-Forwarded-For.
+func resolveClientIP(r *http.Request, trusted *trustedProxies, proxyProtocolForce bool) string {
+	remoteIP := normalizeClientIP(r.RemoteAddr)
+	if remoteIP == "" {
+		remoteIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+		return remoteIP
+	}
+	if !trusted.trustsHeaders(net.ParseIP(remoteIP), proxyProtocolForce) {
+		return remoteIP
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := normalizeClientIP(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		return remoteIP
+	}
+
+	clientIP := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := normalizeClientIP(hops[i])
+		if ip == "" {
+			break
+		}
+		clientIP = ip
+		if !trusted.trustsHeaders(net.ParseIP(ip), proxyProtocolForce) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// normalizeClientIP accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port" and
+// returns the address in canonical form, with IPv4-mapped IPv6 addresses
+// reported as IPv4. It returns "" for anything that is not an IP address.
+func normalizeClientIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	if i := strings.IndexByte(value, '%'); i >= 0 {
+		value = value[:i]
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
+
+func (h *Handler) GetTrustedProxies() models.TrustedProxyConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	cfg := h.TrustedProxies
+	cfg.Proxies = append([]models.TrustedProxy{}, cfg.Proxies...)
+	return cfg
+}
+
+func (h *Handler) SetTrustedProxies(cfg models.TrustedProxyConfig) error {
+	proxies := make([]models.TrustedProxy, 0, len(cfg.Proxies))
+	for _, entry := range cfg.Proxies {
+		entry.ProxyProtocol = strings.ToLower(entry.ProxyProtocol)
+		if entry.ProxyProtocol == "" {
+			entry.ProxyProtocol = proxyProtocolUse
+		}
+		proxies = append(proxies, entry)
+	}
+	cfg.Proxies = proxies
+	cfg.ProxyProtocol = strings.ToLower(cfg.ProxyProtocol)
+	if cfg.ProxyProtocol == "" {
+		cfg.ProxyProtocol = proxyProtocolIgnore
+	}
+	list, err := parseTrustedProxies(cfg)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.TrustedProxies = cfg
+	h.trustedProxies.Store(list)
+	h.saveConfigLocked()
+	return nil
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-reauth-proxy/pkg/models"
+)
+
+func buildUpstreamTLSConfig(opts *models.UpstreamTLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(opts.CA)) {
+			return nil, fmt.Errorf("ca does not contain any valid PEM certificate")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.Cert != "" || opts.Key != "" {
+		if opts.Cert == "" || opts.Key == "" {
+			return nil, fmt.Errorf("cert and key must be provided together")
+		}
+		cert, err := tls.X509KeyPair([]byte(opts.Cert), []byte(opts.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func validateUpstreamTLS(rule models.Rule) error {
+	if rule.TLS == nil {
+		return nil
+	}
+	u, socketPath, err := parseRuleTarget(rule.Target)
+	if err != nil {
+		return err
+	}
+	if socketPath != "" || u.Scheme != "https" {
+		return fmt.Errorf("tls options require an https:// or wss:// target")
+	}
+	if _, err := buildUpstreamTLSConfig(rule.TLS); err != nil {
+		return fmt.Errorf("invalid tls: %v", err)
+	}
+	return nil
+}
+
+// redactUpstreamTLSKeys returns a copy of rules without upstream private
+// keys, for API responses.
+func redactUpstreamTLSKeys(rules []models.Rule) []models.Rule {
+	out := make([]models.Rule, len(rules))
+	for i, rule := range rules {
+		if rule.TLS != nil && rule.TLS.Key != "" {
+			opts := *rule.TLS
+			opts.Key = ""
+			rule.TLS = &opts
+		}
+		out[i] = rule
+	}
+	return out
+}
+
+// keepUpstreamTLSKeys fills in the stored private key of rules that omit it
+// but present the same client certificate as a previous rule, so rules read
+// back from the API can be sent again unchanged.
+func keepUpstreamTLSKeys(rules, previous []models.Rule) {
+	for i := range rules {
+		opts := rules[i].TLS
+		if opts == nil || opts.Cert == "" || opts.Key != "" {
+			continue
+		}
+		for _, prev := range previous {
+			if prev.TLS != nil && prev.TLS.Cert == opts.Cert && prev.TLS.Key != "" {
+				kept := *opts
+				kept.Key = prev.TLS.Key
+				rules[i].TLS = &kept
+				break
+			}
+		}
+	}
+}
+
+// upstreamTLSFingerprint identifies a TLS configuration inside a transport
+// key, so changing certificates or verification settings rebuilds the pool.
+func upstreamTLSFingerprint(opts *models.UpstreamTLS) string {
+	if opts == nil {
+		return ""
+	}
+	data, _ := json.Marshal(opts)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
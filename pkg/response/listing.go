@@ -0,0 +1,68 @@
+package response
+
+import (
+	"go-reauth-proxy/pkg/version"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+type ListingEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+type listingData struct {
+	pageData
+	Path    string
+	Entries []ListingEntry
+}
+
+const listingContent = `
+{{define "content"}}
+<div class="px-5 py-12 w-full max-w-2xl" style="align-self:flex-start">
+	<h1 class="text-2xl font-semibold tracking-tight mb-4">Index of {{.Path}}</h1>
+	<div class="border border-gray-200 bg-white mb-8">
+		{{if ne .Path "/"}}
+		<a href="../" class="flex justify-between px-4 py-2 text-sm hover:bg-black hover:text-white transition-colors">../</a>
+		{{end}}
+		{{range .Entries}}
+		<a href="{{.Name}}{{if .IsDir}}/{{end}}" class="flex justify-between px-4 py-2 text-sm hover:bg-black hover:text-white transition-colors">
+			<span>{{.Name}}{{if .IsDir}}/{{end}}</span>
+			<span class="text-gray-500">{{if not .IsDir}}{{.Size}} B · {{end}}{{.ModTime.Format "2006-01-02 15:04"}}</span>
+		</a>
+		{{end}}
+	</div>
+	{{template "footer" .}}
+</div>
+{{end}}
+`
+
+var listingTmpl = template.Must(
+	template.New("base").
+		Parse(baseTemplate + footerTemplate + listingContent),
+)
+
+// DirectoryListing renders an index page for a directory served by a files rule.
+func DirectoryListing(w http.ResponseWriter, r *http.Request, urlPath string, entries []ListingEntry) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	data := listingData{
+		pageData: pageData{
+			Title:     "Index of " + urlPath,
+			Year:      time.Now().Year(),
+			Version:   version.Version,
+			BodyClass: "flex justify-center bg-white",
+		},
+		Path:    urlPath,
+		Entries: entries,
+	}
+
+	_ = listingTmpl.ExecuteTemplate(w, "layout", data)
+}
@@ -8,6 +8,7 @@ import (
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -149,6 +150,33 @@ func HTML(w http.ResponseWriter, code int, message string, rules []models.Rule)
 	_ = errorTmpl.ExecuteTemplate(w, "layout", data)
 }
 
+// WantsJSON reports whether a proxied client asked for JSON rather than an
+// HTML page, e.g. fetch/XHR calls from single page applications.
+func WantsJSON(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// ProxyError answers a proxied request with an error page, or with a JSON
+// body when the client prefers JSON. Both use the HTTP status of the code.
+func ProxyError(w http.ResponseWriter, r *http.Request, code int, message string, rules []models.Rule) {
+	if !WantsJSON(r) {
+		HTML(w, code, message, rules)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(mapHTTPStatus(code))
+	_ = json.NewEncoder(w).Encode(Response{
+		Success:   false,
+		Code:      code,
+		Message:   message,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
 func Welcome(w http.ResponseWriter, rules []models.Rule) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -171,6 +199,23 @@ func Welcome(w http.ResponseWriter, rules []models.Rule) {
 	_ = errorTmpl.ExecuteTemplate(w, "layout", data)
 }
 
+func Maintenance(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	data := pageData{
+		Title:     "Maintenance",
+		Message:   message,
+		Year:      time.Now().Year(),
+		ShowBack:  true,
+		Version:   version.Version,
+		BodyClass: "flex items-center justify-center h-screen bg-white",
+	}
+
+	_ = errorTmpl.ExecuteTemplate(w, "layout", data)
+}
+
 func mapHTTPStatus(code int) int {
 	if code >= 200 && code < 600 {
 		return code
@@ -181,6 +226,18 @@ func mapHTTPStatus(code int) int {
 		return http.StatusBadGateway
 	case errors.CodeProxyTimeout:
 		return http.StatusGatewayTimeout
+	case errors.CodeProxyTargetForbidden, errors.CodeProxyClientDenied:
+		return http.StatusForbidden
+	case errors.CodeProxyRateLimited:
+		return http.StatusTooManyRequests
+	case errors.CodeProxyOverloaded:
+		return http.StatusServiceUnavailable
+	case errors.CodeRequestTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errors.CodeHeadersTooLarge:
+		return http.StatusRequestHeaderFieldsTooLarge
+	case errors.CodeRequestTimeout:
+		return http.StatusRequestTimeout
 	case errors.CodeUnauthorized:
 		return http.StatusUnauthorized
 	case errors.CodeNotFound:
@@ -376,10 +376,27 @@ var selectTmpl = template.Must(
 		Parse(baseTemplate + selectContent),
 )
 
+// listedRules returns one rule per path, keeping the first occurrence, so
+// rules that only differ in their match predicates are shown once.
+func listedRules(rules []models.Rule) []models.Rule {
+	seen := make(map[string]struct{}, len(rules))
+	out := make([]models.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if _, ok := seen[rule.Path]; ok {
+			continue
+		}
+		seen[rule.Path] = struct{}{}
+		out = append(out, rule)
+	}
+	return out
+}
+
 func SelectPage(w http.ResponseWriter, rules []models.Rule) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
+	rules = listedRules(rules)
+
 	toolbarHTML := GenerateToolbar(rules, "/__select__")
 
 	data := pageData{
@@ -0,0 +1,26 @@
+package response
+
+import (
+	_ "embed"
+	"go-reauth-proxy/pkg/version"
+	"html"
+	"net/http"
+)
+
+// ShimPath serves the client-side URL shim injected into HTML pages.
+const ShimPath = "/__shim__.js"
+
+//go:embed static/shim.js
+var shimScript []byte
+
+// ShimTag returns the script tag that loads the shim for a rule prefix.
+func ShimTag(prefix string) string {
+	return `<script src="` + ShimPath + `?v=` + version.Version + `" data-prefix="` + html.EscapeString(prefix) + `"></script>`
+}
+
+// ServeShim serves the shim script.
+func ServeShim(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	_, _ = w.Write(shimScript)
+}
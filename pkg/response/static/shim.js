@@ -0,0 +1,89 @@
+// Keeps root-relative URLs used by scripts under the rule prefix the page is
+// served from. The prefix comes from the data-prefix attribute of this tag.
+(function () {
+  var script = document.currentScript;
+  var prefix = script && script.getAttribute("data-prefix");
+  if (!prefix || window.__proxyShimPrefix) {
+    return;
+  }
+  window.__proxyShimPrefix = prefix;
+
+  function hasPrefix(p) {
+    return p.indexOf(prefix) === 0 && (p.length === prefix.length || "/?#".indexOf(p.charAt(prefix.length)) !== -1);
+  }
+
+  function fix(url) {
+    if (url === undefined || url === null) {
+      return url;
+    }
+    var s = String(url);
+    if (s.charAt(0) === "/" && s.charAt(1) !== "/") {
+      return hasPrefix(s) ? s : prefix + s;
+    }
+    // Relative paths already resolve under the current page.
+    if (!/^[a-z][a-z0-9+.-]*:/i.test(s)) {
+      return url;
+    }
+    var u;
+    try {
+      u = new URL(s);
+    } catch (e) {
+      return url;
+    }
+    if (u.host !== location.host || !/^(https?|wss?):$/.test(u.protocol) || hasPrefix(u.pathname)) {
+      return url;
+    }
+    u.pathname = prefix + u.pathname;
+    return u.href;
+  }
+
+  var nativeFetch = window.fetch;
+  if (nativeFetch) {
+    window.fetch = function (input, init) {
+      if (typeof input === "string" || input instanceof URL) {
+        input = fix(input);
+      } else if (input instanceof Request) {
+        var fixed = fix(input.url);
+        if (fixed !== input.url) {
+          input = new Request(fixed, input);
+        }
+      }
+      return nativeFetch.call(this, input, init);
+    };
+  }
+
+  var nativeOpen = XMLHttpRequest.prototype.open;
+  XMLHttpRequest.prototype.open = function (method, url) {
+    var args = Array.prototype.slice.call(arguments);
+    args[1] = fix(url);
+    return nativeOpen.apply(this, args);
+  };
+
+  if (window.WebSocket) {
+    var NativeWebSocket = window.WebSocket;
+    window.WebSocket = class extends NativeWebSocket {
+      constructor(url, protocols) {
+        super(fix(url), protocols);
+      }
+    };
+  }
+
+  if (window.EventSource) {
+    var NativeEventSource = window.EventSource;
+    window.EventSource = class extends NativeEventSource {
+      constructor(url, options) {
+        super(fix(url), options);
+      }
+    };
+  }
+
+  ["pushState", "replaceState"].forEach(function (name) {
+    var native = history[name];
+    history[name] = function (state, title, url) {
+      if (arguments.length > 2) {
+        return native.call(this, state, title, fix(url));
+      }
+      return native.apply(this, arguments);
+    };
+  });
+})();
@@ -616,7 +616,7 @@ func GenerateToolbar(rules []models.Rule, currentPath string) string {
 		Rules       []models.Rule
 		CurrentPath string
 	}{
-		Rules:       rules,
+		Rules:       listedRules(rules),
 		CurrentPath: currentPath,
 	}
 	_ = toolbarTmpl.Execute(&buf, data)